@@ -1,11 +1,223 @@
 package config
 
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Session token sources accepted by the authorization middleware.
+const (
+	TokenSourceHeader = "header" // Authorization: Bearer <sid>
+	TokenSourceCookie = "cookie" // session cookie
+	TokenSourceBody   = "body"   // "sid" field of the JSON body, deprecated
+)
+
+// Session store backends.
+const (
+	SessionStoreRedis  = "redis"
+	SessionStoreMemory = "memory"
+)
+
+// Event bus backends.
+const (
+	EventBusRedis  = "redis"
+	EventBusMemory = "memory"
+)
+
+// Login throttle backends.
+const (
+	LoginThrottleRedis  = "redis"
+	LoginThrottleMemory = "memory"
+)
+
+// Mailer backends.
+const (
+	MailerLog  = "log"  // emails are written to the log
+	MailerFile = "file" // emails are appended to MailFile
+)
+
+// Policies limiting the accounts whose email is not verified.
+const (
+	UnverifiedAllow    = "allow"     // no limits
+	UnverifiedNoShare  = "no_share"  // cannot share notes nor create public links
+	UnverifiedReadOnly = "read_only" // cannot change anything until the email is verified
+)
+
 type Config struct {
 	DatabaseURL string
+
+	// SessionStore selects where sessions are kept, SessionStoreRedis or SessionStoreMemory.
+	SessionStore string
+
+	// SessionTokenSources lists where the SID is looked up, in order of precedence.
+	SessionTokenSources []string
+	// SessionCookieEnabled makes signup and login set the session cookie.
+	SessionCookieEnabled bool
+	SessionCookieName    string
+	SessionCookieDomain  string
+	SessionCookieSecure  bool
+
+	// SessionIdleTimeout ends a session that was not used for this long.
+	SessionIdleTimeout time.Duration
+	// SessionMaxLifetime ends a session this long after it was created, however active.
+	SessionMaxLifetime time.Duration
+
+	// EventBus selects how note events reach the clients, EventBusRedis fans them out
+	// across instances while EventBusMemory only serves a single instance.
+	EventBus string
+	// EventRetention is how long events are kept for clients resuming their stream.
+	EventRetention time.Duration
+
+	// CollabSaveInterval is how often the content of notes edited together is saved.
+	CollabSaveInterval time.Duration
+	// CollabIdleTimeout is how long an editing session outlives its last client,
+	// so that reconnecting clients catch up instead of reloading the note.
+	CollabIdleTimeout time.Duration
+
+	// AppURL is the base URL of the application, used in the links sent by email.
+	AppURL string
+
+	// Mailer selects how emails are delivered, MailerLog or MailerFile.
+	Mailer   string
+	MailFile string
+	MailFrom string
+
+	// PasswordResetTTL is how long a password reset token stays valid.
+	PasswordResetTTL time.Duration
+
+	// EmailVerificationTTL is how long an email verification token stays valid.
+	EmailVerificationTTL time.Duration
+	// UnverifiedPolicy limits the accounts whose email is not verified, UnverifiedAllow,
+	// UnverifiedNoShare or UnverifiedReadOnly.
+	UnverifiedPolicy string
+
+	// TwoFactorKey is the base64 key of 32 bytes encrypting the two-factor secrets,
+	// two-factor authentication cannot be set up without it.
+	TwoFactorKey string
+	// TwoFactorIssuer names the service in authenticator apps.
+	TwoFactorIssuer string
+	// TwoFactorChallengeTTL is how long a login waits for its two-factor code.
+	TwoFactorChallengeTTL time.Duration
+
+	// LoginThrottle selects where failed logins are counted, LoginThrottleRedis
+	// shares them across instances while LoginThrottleMemory only serves a single instance.
+	LoginThrottle string
+	// LoginFailureWindow is how long failed logins are remembered after the last one.
+	LoginFailureWindow time.Duration
+	// LoginDelayAfter failures, every further login of an email or IP is delayed
+	// by LoginBaseDelay doubled with each failure.
+	LoginDelayAfter int
+	LoginBaseDelay  time.Duration
+	// LoginMaxFailures locks the logins of an email for LoginLockout,
+	// LoginMaxFailuresPerIP the logins from an IP.
+	LoginMaxFailures      int
+	LoginMaxFailuresPerIP int
+	LoginLockout          time.Duration
+	// TrustedProxies lists the IPs and CIDRs of the proxies whose X-Forwarded-For
+	// header gives the IP of the client. Without any, the IP of the connection is used.
+	TrustedProxies []string
+
+	// TrashRetention is how long deleted notes stay in the trash before being purged.
+	TrashRetention time.Duration
+	// TrashPurgeInterval is how often the trash is checked for notes to purge.
+	TrashPurgeInterval time.Duration
+	// SyncTombstoneRetention is how long permanent deletions are kept for syncing
+	// clients, clients that did not sync for longer get all their notes again.
+	SyncTombstoneRetention time.Duration
 }
 
 func LoadConfig(databaseUrl string) Config {
 	return Config{
-		DatabaseURL: databaseUrl,
+		DatabaseURL:            databaseUrl,
+		SessionStore:           getEnv("SESSION_STORE", SessionStoreRedis),
+		SessionTokenSources:    getEnvList("SESSION_TOKEN_SOURCES", []string{TokenSourceHeader, TokenSourceCookie, TokenSourceBody}),
+		SessionCookieEnabled:   getEnvBool("SESSION_COOKIE_ENABLED", true),
+		SessionCookieName:      getEnv("SESSION_COOKIE_NAME", "sid"),
+		SessionCookieDomain:    getEnv("SESSION_COOKIE_DOMAIN", ""),
+		SessionCookieSecure:    getEnvBool("SESSION_COOKIE_SECURE", true),
+		SessionIdleTimeout:     getEnvDuration("SESSION_IDLE_TIMEOUT", time.Hour),
+		SessionMaxLifetime:     getEnvDuration("SESSION_MAX_LIFETIME", 7*24*time.Hour),
+		EventBus:               getEnv("EVENT_BUS", EventBusRedis),
+		EventRetention:         getEnvDuration("EVENT_RETENTION", 24*time.Hour),
+		CollabSaveInterval:     getEnvDuration("COLLAB_SAVE_INTERVAL", 2*time.Second),
+		CollabIdleTimeout:      getEnvDuration("COLLAB_IDLE_TIMEOUT", time.Minute),
+		AppURL:                 getEnv("APP_URL", "http://localhost:8080"),
+		Mailer:                 getEnv("MAILER", MailerLog),
+		MailFile:               getEnv("MAIL_FILE", "mail.log"),
+		MailFrom:               getEnv("MAIL_FROM", "no-reply@localhost"),
+		PasswordResetTTL:       getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+		EmailVerificationTTL:   getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		UnverifiedPolicy:       getEnv("UNVERIFIED_POLICY", UnverifiedNoShare),
+		TwoFactorKey:           getEnv("TWO_FACTOR_KEY", ""),
+		TwoFactorIssuer:        getEnv("TWO_FACTOR_ISSUER", "Notes"),
+		TwoFactorChallengeTTL:  getEnvDuration("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute),
+		LoginThrottle:          getEnv("LOGIN_THROTTLE", LoginThrottleRedis),
+		LoginFailureWindow:     getEnvDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+		LoginDelayAfter:        getEnvInt("LOGIN_DELAY_AFTER", 3),
+		LoginBaseDelay:         getEnvDuration("LOGIN_BASE_DELAY", time.Second),
+		LoginMaxFailures:       getEnvInt("LOGIN_MAX_FAILURES", 10),
+		LoginMaxFailuresPerIP:  getEnvInt("LOGIN_MAX_FAILURES_PER_IP", 100),
+		LoginLockout:           getEnvDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		TrustedProxies:         getEnvList("TRUSTED_PROXIES", nil),
+		TrashRetention:         getEnvDuration("TRASH_RETENTION", 30*24*time.Hour),
+		TrashPurgeInterval:     getEnvDuration("TRASH_PURGE_INTERVAL", time.Hour),
+		SyncTombstoneRetention: getEnvDuration("SYNC_TOMBSTONE_RETENTION", 90*24*time.Hour),
+	}
+}
+
+// getEnv returns the value of the environment variable or the fallback if unset.
+func getEnv(key, fallback string) string {
+	if val, ok := os.LookupEnv(key); ok && val != "" {
+		return val
+	}
+	return fallback
+}
+
+// getEnvBool returns the boolean value of the environment variable or the fallback
+// if it is unset or invalid.
+func getEnvBool(key string, fallback bool) bool {
+	val, err := strconv.ParseBool(getEnv(key, strconv.FormatBool(fallback)))
+	if err != nil {
+		return fallback
+	}
+	return val
+}
+
+// getEnvInt returns the positive integer value of the environment variable or the
+// fallback if it is unset or invalid.
+func getEnvInt(key string, fallback int) int {
+	val, err := strconv.Atoi(getEnv(key, strconv.Itoa(fallback)))
+	if err != nil || val <= 0 {
+		return fallback
+	}
+	return val
+}
+
+// getEnvDuration returns the duration value of the environment variable or the
+// fallback if it is unset or invalid.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val, err := time.ParseDuration(getEnv(key, fallback.String()))
+	if err != nil || val <= 0 {
+		return fallback
+	}
+	return val
+}
+
+// getEnvList returns the comma separated values of the environment variable or
+// the fallback if it is unset.
+func getEnvList(key string, fallback []string) []string {
+	val := getEnv(key, "")
+	if val == "" {
+		return fallback
+	}
+
+	var list []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
 	}
+	return list
 }
@@ -1,7 +1,52 @@
 package config
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+	"time"
+)
 
 func TestLoadConfig(t *testing.T) {
-	// cfg := LoadConfig()
+	t.Setenv("SESSION_TOKEN_SOURCES", "")
+	t.Setenv("SESSION_COOKIE_SECURE", "")
+	t.Setenv("SESSION_IDLE_TIMEOUT", "")
+
+	cfg := LoadConfig("postgres://db")
+	if cfg.DatabaseURL != "postgres://db" {
+		t.Errorf("DatabaseURL = %q", cfg.DatabaseURL)
+	}
+
+	want := []string{TokenSourceHeader, TokenSourceCookie, TokenSourceBody}
+	if !reflect.DeepEqual(cfg.SessionTokenSources, want) {
+		t.Errorf("SessionTokenSources = %v, want %v", cfg.SessionTokenSources, want)
+	}
+	if !cfg.SessionCookieSecure {
+		t.Error("SessionCookieSecure should default to true")
+	}
+	if cfg.SessionIdleTimeout != time.Hour {
+		t.Errorf("SessionIdleTimeout = %v, want %v", cfg.SessionIdleTimeout, time.Hour)
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("SESSION_TOKEN_SOURCES", " cookie, header ,")
+	t.Setenv("SESSION_COOKIE_SECURE", "false")
+	t.Setenv("SESSION_IDLE_TIMEOUT", "15m")
+	t.Setenv("SESSION_MAX_LIFETIME", "not-a-duration")
+
+	cfg := LoadConfig("")
+
+	want := []string{TokenSourceCookie, TokenSourceHeader}
+	if !reflect.DeepEqual(cfg.SessionTokenSources, want) {
+		t.Errorf("SessionTokenSources = %v, want %v", cfg.SessionTokenSources, want)
+	}
+	if cfg.SessionCookieSecure {
+		t.Error("SessionCookieSecure should be false")
+	}
+	if cfg.SessionIdleTimeout != 15*time.Minute {
+		t.Errorf("SessionIdleTimeout = %v, want %v", cfg.SessionIdleTimeout, 15*time.Minute)
+	}
+	if cfg.SessionMaxLifetime != 7*24*time.Hour {
+		t.Errorf("SessionMaxLifetime = %v, want the default", cfg.SessionMaxLifetime)
+	}
 }
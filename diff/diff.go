@@ -0,0 +1,183 @@
+// Package diff computes line based unified diffs between texts.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around each change.
+const contextLines = 3
+
+// maxCells bounds the size of the LCS table, about 1MB, past it the changed
+// region is reported as a whole replacement instead of a minimal diff.
+const maxCells = 250_000
+
+// op is a line of the edit script turning a into b.
+type op struct {
+	kind byte // ' ' unchanged, '-' removed from a, '+' added from b
+	text string
+	ai   int // index in a of the line, or where it is inserted
+	bi   int // index in b of the line, or where it is removed
+}
+
+// Unified returns the unified diff turning from into to, labelled with the given
+// names. It returns an empty string if both texts are equal.
+func Unified(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+
+	ops := editScript(splitLines(from), splitLines(to))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(ops); {
+		// Find the next change
+		start := i
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// Extend the hunk while the next change is close enough to share context
+		end := start
+		for {
+			next := end + 1
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*contextLines {
+				break
+			}
+			end = next
+		}
+
+		from := start - contextLines
+		if from < i {
+			from = i
+		}
+		to := end + contextLines + 1
+		if to > len(ops) {
+			to = len(ops)
+		}
+		writeHunk(&sb, ops[from:to])
+		i = to
+	}
+
+	return sb.String()
+}
+
+// writeHunk writes the header and the lines of a hunk.
+func writeHunk(sb *strings.Builder, hunk []op) {
+	aCount, bCount := 0, 0
+	for _, o := range hunk {
+		if o.kind != '+' {
+			aCount++
+		}
+		if o.kind != '-' {
+			bCount++
+		}
+	}
+
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(hunk[0].ai, aCount), hunkRange(hunk[0].bi, bCount))
+	for _, o := range hunk {
+		sb.WriteByte(o.kind)
+		sb.WriteString(o.text)
+		sb.WriteByte('\n')
+	}
+}
+
+// hunkRange formats the range of a hunk side, empty ranges refer to the line before.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// editScript returns the operations turning a into b, based on their longest common subsequence.
+func editScript(a, b []string) []op {
+	// Common prefix and suffix are unchanged and kept out of the LCS table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, op{' ', a[i], i, i})
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(ma), len(mb)
+
+	if n*m > maxCells {
+		// Too large for an exact diff, replace the whole changed region
+		for i := 0; i < n; i++ {
+			ops = append(ops, op{'-', ma[i], prefix + i, prefix})
+		}
+		for j := 0; j < m; j++ {
+			ops = append(ops, op{'+', mb[j], prefix + n, prefix + j})
+		}
+	} else {
+		// lcs(i, j) is the length of the LCS of ma[i:] and mb[j:], kept in a single array
+		table := make([]int32, (n+1)*(m+1))
+		lcs := func(i, j int) int32 {
+			return table[i*(m+1)+j]
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				cell := &table[i*(m+1)+j]
+				if ma[i] == mb[j] {
+					*cell = lcs(i+1, j+1) + 1
+				} else if lcs(i+1, j) >= lcs(i, j+1) {
+					*cell = lcs(i+1, j)
+				} else {
+					*cell = lcs(i, j+1)
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && ma[i] == mb[j]:
+				ops = append(ops, op{' ', ma[i], prefix + i, prefix + j})
+				i++
+				j++
+			case i < n && (j == m || lcs(i+1, j) >= lcs(i, j+1)):
+				// Removals come before additions, as in the usual diff output
+				ops = append(ops, op{'-', ma[i], prefix + i, prefix + j})
+				i++
+			default:
+				ops = append(ops, op{'+', mb[j], prefix + i, prefix + j})
+				j++
+			}
+		}
+	}
+
+	for k := suffix; k > 0; k-- {
+		ops = append(ops, op{' ', a[len(a)-k], len(a) - k, len(b) - k})
+	}
+
+	return ops
+}
+
+// splitLines splits a text into lines, ignoring the newline ending the last line.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,67 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{
+			name: "equal",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "change in the middle",
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			to:   "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "insert into empty text",
+			from: "",
+			to:   "hello\nworld",
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+hello\n+world\n",
+		},
+		{
+			name: "distant changes make separate hunks",
+			from: "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			to:   "A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n",
+		},
+		{
+			name: "close changes share a hunk",
+			from: "a\n1\n2\nb\n",
+			to:   "A\n1\n2\nB\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n-b\n+B\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unified("a", "b", tt.from, tt.to); got != tt.want {
+				t.Errorf("Unified() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedLargeChange(t *testing.T) {
+	// Past the LCS table bound the changed region is replaced as a whole
+	from := strings.Repeat("a\nb\n", 400)
+	to := strings.Repeat("b\na\n", 400)
+
+	got := Unified("a", "b", from, to)
+	if want := "--- a\n+++ b\n@@ -1,800 +1,800 @@\n"; !strings.HasPrefix(got, want) {
+		t.Fatalf("Unified() starts with %q, want %q", got[:40], want)
+	}
+	if n := strings.Count(got, "\n-"); n != 800 {
+		t.Errorf("Unified() removes %d lines, want 800", n)
+	}
+}
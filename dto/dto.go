@@ -1,5 +1,7 @@
 package dto
 
+import "time"
+
 // Define a SignUpRequest struct to represent the JSON request body
 type SignUpRequest struct {
 	Name     string `json:"name" binding:"required"`
@@ -12,10 +14,101 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// ForgotPasswordRequest defines the JSON request format for asking a password reset email.
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest defines the JSON request format for setting a new password
+// with the token of a password reset email.
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// VerifyEmailRequest defines the JSON request format for verifying an email with
+// the token of a verification email.
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// TwoFactorCodeRequest defines the JSON request format for confirming two-factor
+// authentication with a code of the authenticator.
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// UpdateProfileRequest defines the JSON request format for partial profile updates.
+// Changing the email takes the password, the new email is pending until verified.
+type UpdateProfileRequest struct {
+	Name     *string `json:"name"`
+	Email    *string `json:"email" binding:"omitempty,email"`
+	Password string  `json:"password"`
+}
+
+// ChangePasswordRequest defines the JSON request format for changing the password
+// of the current user.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// DeleteAccountRequest defines the JSON request format for deleting the account
+// of the current user. Code is only needed with two-factor authentication enabled.
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code"`
+}
+
+// DisableTwoFactorRequest defines the JSON request format for disabling two-factor
+// authentication. Code is a code of the authenticator or a recovery code.
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// TwoFactorLoginRequest defines the JSON request format for completing a login
+// challenge with a code of the authenticator or a recovery code.
+type TwoFactorLoginRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+}
+
 type LoginResponse struct {
 	SID string `json:"sid"`
 }
 
+// SessionResponse describes an active session of the user.
+type SessionResponse struct {
+	ID            uint      `json:"id"`
+	IP            string    `json:"ip"`
+	UserAgent     string    `json:"user_agent"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastSeenAt    time.Time `json:"last_seen_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	IdleExpiresAt time.Time `json:"idle_expires_at"`
+	Current       bool      `json:"current"`
+}
+
+// ShareResponse describes a share of a note with the user it was granted to.
+type ShareResponse struct {
+	ID         uint          `json:"id"`
+	NoteID     uint          `json:"note_id"`
+	User       ShareUserInfo `json:"user"`
+	Permission string        `json:"permission"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+}
+
+// ShareUserInfo is what the owner of a note sees of the users it is shared with.
+type ShareUserInfo struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// AuthRequest carries the SID in the JSON body.
+//
+// Deprecated: send the SID in the Authorization header or the session cookie.
 type AuthRequest struct {
 	SID string `json:"sid"`
 }
@@ -25,12 +118,126 @@ type NotesRequest struct {
 	SID string `json:"sid"`
 }
 
+// ListNotesQuery defines the query parameters of the /notes listing.
+type ListNotesQuery struct {
+	Limit  int       `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor string    `form:"cursor"`
+	Sort   string    `form:"sort" binding:"omitempty,oneof=created_at updated_at"`
+	Order  string    `form:"order" binding:"omitempty,oneof=asc desc"`
+	From   time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To     time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	// Tag filters the notes by tag, TagMode selects whether they need all or any of the tags.
+	Tag     []string `form:"tag"`
+	TagMode string   `form:"tag_mode" binding:"omitempty,oneof=all any"`
+	// NotebookID lists the notes of a notebook, including nested notebooks if Recursive is set.
+	NotebookID *uint `form:"notebook_id"`
+	Recursive  bool  `form:"recursive"`
+}
+
+// SearchNotesQuery defines the query parameters of the /notes/search endpoint.
+type SearchNotesQuery struct {
+	Q      string `form:"q" binding:"required"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Offset int    `form:"offset" binding:"omitempty,min=0"`
+}
+
+// SyncQuery defines the query parameters of a sync pull.
+type SyncQuery struct {
+	// Since is the cursor returned by the previous pull, omitted on the first sync.
+	Since string `form:"since"`
+	Limit int    `form:"limit" binding:"omitempty,min=1,max=1000"`
+}
+
+// SyncPushRequest defines the JSON request format for pushing the changes a client made offline.
+type SyncPushRequest struct {
+	Changes []SyncChange `json:"changes" binding:"required,min=1,max=100,dive"`
+}
+
+// SyncChange is a change of a note in a SyncPushRequest. Updates and deletes
+// need the ID of the note and the version the client last saw.
+type SyncChange struct {
+	Action     string    `json:"action" binding:"required,oneof=create update delete"`
+	NoteID     uint      `json:"note_id"`
+	Version    uint      `json:"version"`
+	Title      *string   `json:"title"`
+	Note       *string   `json:"note"`
+	Tags       *[]string `json:"tags"`
+	NotebookID *uint     `json:"notebook_id"`
+}
+
 type DeleteNoteRequest struct {
 	SID string `json:"sid"`
 	ID  uint32 `json:"id"`
+	// Version is the version of the note the client saw, required without an If-Match header.
+	Version *uint `json:"version"`
 }
 
 type CreateNoteRequest struct {
-	SID  string `json:"sid"`
-	Note string `json:"note"`
+	SID   string   `json:"sid"`
+	Title string   `json:"title"`
+	Note  string   `json:"note"`
+	Tags  []string `json:"tags"`
+	// NotebookID places the note in a notebook.
+	NotebookID *uint `json:"notebook_id"`
+}
+
+// UpdateNoteRequest defines the JSON request format for partial note updates.
+// Fields that are omitted are left unchanged.
+type UpdateNoteRequest struct {
+	Title *string   `json:"title"`
+	Note  *string   `json:"note"`
+	Tags  *[]string `json:"tags"` // replaces all tags of the note
+	// NotebookID moves the note to another notebook, 0 moves it out of notebooks.
+	NotebookID *uint `json:"notebook_id"`
+	// Version is the version of the note the client saw, required without an If-Match header.
+	Version *uint `json:"version"`
+}
+
+// ShareNoteRequest defines the JSON request format for sharing a note with another user.
+type ShareNoteRequest struct {
+	Email      string `json:"email" binding:"required,email"`
+	Permission string `json:"permission" binding:"required,oneof=read edit"`
+}
+
+// CreatePublicLinkRequest defines the JSON request format for creating a public link to a note.
+// The body is optional, links without expiry or password are created by default.
+type CreatePublicLinkRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+	Password  string     `json:"password"`
+}
+
+// CollabQuery defines the query parameters for joining the editing session of a note.
+// Reconnecting clients pass the session and the last revision they know.
+type CollabQuery struct {
+	SessionID string `form:"session_id"`
+	Revision  int    `form:"revision" binding:"min=0"`
+}
+
+// RenameTagRequest defines the JSON request format for renaming a tag.
+type RenameTagRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// CreateNotebookRequest defines the JSON request format for creating a notebook.
+type CreateNotebookRequest struct {
+	Name     string `json:"name" binding:"required"`
+	ParentID *uint  `json:"parent_id"`
+}
+
+// UpdateNotebookRequest defines the JSON request format for partial notebook updates.
+type UpdateNotebookRequest struct {
+	Name     *string `json:"name"`
+	ParentID *uint   `json:"parent_id"` // 0 moves the notebook to the top level
+}
+
+// DeleteNotebookQuery defines the query parameters for deleting a notebook.
+type DeleteNotebookQuery struct {
+	// Mode "cascade" deletes the content of the notebook, "reparent" moves it to the parent notebook.
+	Mode string `form:"mode" binding:"omitempty,oneof=cascade reparent"`
+}
+
+// DiffRevisionsQuery defines the query parameters of the revision diff endpoint.
+type DiffRevisionsQuery struct {
+	From uint `form:"from" binding:"required,min=1"`
+	To   uint `form:"to" binding:"required,min=1"`
 }
@@ -1,21 +1,39 @@
 package handler
 
 import (
+	"accuknox/config"
 	"accuknox/dto"
 	"accuknox/model"
 	"accuknox/myerrors"
 	"accuknox/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"golang.org/x/net/websocket"
 )
 
 // UserServiceHandler defines methods for user-related handlers.
 type UserServiceHandler interface {
 	SignUpHandler(c *gin.Context)
 	LoginHandler(c *gin.Context)
+	ForgotPasswordHandler(c *gin.Context)
+	ResetPasswordHandler(c *gin.Context)
+	VerifyEmailHandler(c *gin.Context)
+	ResendVerificationHandler(c *gin.Context)
+	GetProfileHandler(c *gin.Context)
+	UpdateProfileHandler(c *gin.Context)
+	ChangePasswordHandler(c *gin.Context)
+	DeleteAccountHandler(c *gin.Context)
 	// Add more user-related handlers here
 }
 
@@ -23,18 +41,81 @@ type UserServiceHandler interface {
 type NoteServiceHandler interface {
 	CreateNoteHandler(c *gin.Context)
 	GetAllUserNotesHandler(c *gin.Context)
+	GetNoteHandler(c *gin.Context)
+	SearchNotesHandler(c *gin.Context)
+	UpdateNoteHandler(c *gin.Context)
 	DeleteNoteHandler(c *gin.Context)
+	GetTrashHandler(c *gin.Context)
+	RestoreNoteHandler(c *gin.Context)
+	EmptyTrashHandler(c *gin.Context)
+	GetRevisionsHandler(c *gin.Context)
+	GetRevisionHandler(c *gin.Context)
+	DiffRevisionsHandler(c *gin.Context)
+	RestoreRevisionHandler(c *gin.Context)
+	SyncPullHandler(c *gin.Context)
+	SyncPushHandler(c *gin.Context)
 	// Add more note-related handlers here
 }
 
+// TagServiceHandler defines methods for tag-related handlers.
+type TagServiceHandler interface {
+	GetTagsHandler(c *gin.Context)
+	RenameTagHandler(c *gin.Context)
+	DeleteTagHandler(c *gin.Context)
+}
+
+// ShareServiceHandler defines methods for handlers sharing notes with other users.
+type ShareServiceHandler interface {
+	CreateShareHandler(c *gin.Context)
+	GetSharesHandler(c *gin.Context)
+	DeleteShareHandler(c *gin.Context)
+	GetSharedWithMeHandler(c *gin.Context)
+}
+
+// PublicLinkServiceHandler defines methods for handlers of public links to notes.
+type PublicLinkServiceHandler interface {
+	CreateLinkHandler(c *gin.Context)
+	GetLinksHandler(c *gin.Context)
+	DeleteLinkHandler(c *gin.Context)
+	ViewPublicNoteHandler(c *gin.Context)
+}
+
+// EventServiceHandler defines methods for handlers streaming note events.
+type EventServiceHandler interface {
+	StreamEventsHandler(c *gin.Context)
+}
+
+// TwoFactorServiceHandler defines methods for handlers of two-factor authentication.
+type TwoFactorServiceHandler interface {
+	SetupHandler(c *gin.Context)
+	ConfirmHandler(c *gin.Context)
+	DisableHandler(c *gin.Context)
+	LoginHandler(c *gin.Context)
+}
+
+// CollabServiceHandler defines methods for handlers of collaborative editing.
+type CollabServiceHandler interface {
+	CollabHandler(c *gin.Context)
+}
+
+// NotebookServiceHandler defines methods for notebook-related handlers.
+type NotebookServiceHandler interface {
+	CreateNotebookHandler(c *gin.Context)
+	GetNotebooksHandler(c *gin.Context)
+	GetNotebookHandler(c *gin.Context)
+	UpdateNotebookHandler(c *gin.Context)
+	DeleteNotebookHandler(c *gin.Context)
+}
+
 // userHandler implements UserServiceHandler.
 type userHandler struct {
 	userService service.UserService
+	cfg         config.Config
 }
 
 // NewUserHandler creates a new userHandler with the provided UserService.
-func NewUserHandler(userService service.UserService) UserServiceHandler {
-	return &userHandler{userService}
+func NewUserHandler(userService service.UserService, cfg config.Config) UserServiceHandler {
+	return &userHandler{userService, cfg}
 }
 
 func (h *userHandler) SignUpHandler(c *gin.Context) {
@@ -48,12 +129,14 @@ func (h *userHandler) SignUpHandler(c *gin.Context) {
 	}
 
 	// Call the UserService to create the user
-	sessionID, err := h.userService.CreateUser(req.Name, req.Email, req.Password)
+	sessionID, err := h.userService.CreateUser(req.Name, req.Email, req.Password, clientInfo(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
+	setSessionCookie(c, h.cfg, sessionID)
+
 	// Respond with the created user
 	c.JSON(http.StatusOK, gin.H{"sid": sessionID})
 }
@@ -69,22 +152,510 @@ func (h *userHandler) LoginHandler(c *gin.Context) {
 	}
 
 	// Call the UserService's Login method to authenticate the user and obtain the session ID (SID)
-	sessionID, err := h.userService.Login(req.Email, req.Password)
+	result, err := h.userService.Login(req.Email, req.Password, clientInfo(c))
 	if err != nil {
-		if err == myerrors.ErrAuthentication {
+		switch err {
+		case myerrors.ErrAuthentication:
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
-		} else {
+		case myerrors.ErrTooManyAttempts:
+			tooManyAttempts(c, result.RetryAfter)
+		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
 		}
 		return
 	}
 
+	// The session is only created once the challenge is completed with a code
+	if result.Challenge != "" {
+		c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "challenge": result.Challenge})
+		return
+	}
+
+	setSessionCookie(c, h.cfg, result.SID)
+
 	// Respond with the session ID (SID)
-	c.JSON(http.StatusOK, gin.H{"sid": sessionID})
+	c.JSON(http.StatusOK, gin.H{"sid": result.SID})
+}
+
+// ForgotPasswordHandler emails a password reset link. It answers the same whether
+// the account exists or not.
+func (h *userHandler) ForgotPasswordHandler(c *gin.Context) {
+	var req dto.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("[ForgotPasswordHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	if err := h.userService.RequestPasswordReset(req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send password reset email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for this email, a password reset link was sent to it"})
+}
+
+// ResetPasswordHandler sets a new password with the token of a password reset
+// email. All sessions of the user are ended, they log in again with the new password.
+func (h *userHandler) ResetPasswordHandler(c *gin.Context) {
+	var req dto.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("[ResetPasswordHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	if err := h.userService.ResetPassword(req.Token, req.Password); err != nil {
+		switch err {
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or already used reset token"})
+		case myerrors.ErrExpired:
+			c.JSON(http.StatusGone, gin.H{"error": "Reset token expired"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset, please log in again"})
+}
+
+// VerifyEmailHandler marks the email of a user as verified with the token of a
+// verification email. It needs no session so that the link works on any device.
+func (h *userHandler) VerifyEmailHandler(c *gin.Context) {
+	var req dto.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("[VerifyEmailHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	if err := h.userService.VerifyEmail(req.Token); err != nil {
+		switch err {
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or already used verification token"})
+		case myerrors.ErrExpired:
+			c.JSON(http.StatusGone, gin.H{"error": "Verification token expired"})
+		case myerrors.ErrConflict:
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ResendVerificationHandler sends a new verification email to the current user.
+func (h *userHandler) ResendVerificationHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	if err := h.userService.ResendVerificationEmail(userID.(uint)); err != nil {
+		if err == myerrors.ErrConflict {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already verified"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// GetProfileHandler returns the profile of the current user.
+func (h *userHandler) GetProfileHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	user, err := h.userService.GetUser(userID.(uint))
+	if err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get profile"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateProfileHandler changes the name and/or email of the current user. A new
+// email takes the password and is sent a link, it replaces the current one once followed.
+func (h *userHandler) UpdateProfileHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	var req dto.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("[UpdateProfileHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Name == nil && req.Email == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+
+	user, err := h.userService.UpdateProfile(userID.(uint), model.UserUpdate{
+		Name:  req.Name,
+		Email: req.Email,
+	}, req.Password, clientInfo(c))
+	if err != nil {
+		if refusedAttempt(c, err) {
+			return
+		}
+		switch err {
+		case myerrors.ErrInvalidInput:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid name"})
+		case myerrors.ErrAuthentication:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+		case myerrors.ErrConflict:
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ChangePasswordHandler sets a new password for the current user given their
+// current one. Their other sessions are ended, the current one stays.
+func (h *userHandler) ChangePasswordHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	sid, _ := c.Get("sid")
+
+	var req dto.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("[ChangePasswordHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	if err := h.userService.ChangePassword(userID.(uint), sid.(string), req.CurrentPassword, req.NewPassword, clientInfo(c)); err != nil {
+		if refusedAttempt(c, err) {
+			return
+		}
+		switch err {
+		case myerrors.ErrAuthentication:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid current password"})
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, other sessions were logged out"})
+}
+
+// DeleteAccountHandler permanently deletes the current user with their notes,
+// shares and sessions, given their password and a code if they enabled two-factor authentication.
+func (h *userHandler) DeleteAccountHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	var req dto.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("[DeleteAccountHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	if err := h.userService.DeleteAccount(userID.(uint), req.Password, req.Code, clientInfo(c)); err != nil {
+		if refusedAttempt(c, err) {
+			return
+		}
+		switch err {
+		case myerrors.ErrAuthentication:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or code"})
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case myerrors.ErrNotConfigured:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Two-factor authentication is not available"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		}
+		return
+	}
+
+	clearSessionCookie(c, h.cfg)
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
 }
 
 // Add more user-related handlers here
 
+// twoFactorHandler implements TwoFactorServiceHandler.
+type twoFactorHandler struct {
+	twoFactorService service.TwoFactorService
+	cfg              config.Config
+}
+
+// NewTwoFactorHandler creates a new twoFactorHandler with the provided TwoFactorService.
+func NewTwoFactorHandler(twoFactorService service.TwoFactorService, cfg config.Config) TwoFactorServiceHandler {
+	return &twoFactorHandler{twoFactorService, cfg}
+}
+
+// SetupHandler returns a new secret for the authenticator of the user, to be
+// confirmed with a code before two-factor authentication is enabled.
+func (h *twoFactorHandler) SetupHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	setup, err := h.twoFactorService.Setup(userID.(uint))
+	if err != nil {
+		switch err {
+		case myerrors.ErrNotConfigured:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Two-factor authentication is not available"})
+		case myerrors.ErrConflict:
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up two-factor authentication"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+// ConfirmHandler enables two-factor authentication with a code of the authenticator
+// and returns the recovery codes, which are not shown again.
+func (h *twoFactorHandler) ConfirmHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	var req dto.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("[ConfirmHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	codes, err := h.twoFactorService.Confirm(userID.(uint), req.Code)
+	if err != nil {
+		switch err {
+		case myerrors.ErrAuthentication:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication was not set up"})
+		case myerrors.ErrConflict:
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableHandler disables two-factor authentication given the password and a code of the user.
+func (h *twoFactorHandler) DisableHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	var req dto.DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("[DisableHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	if err := h.twoFactorService.Disable(userID.(uint), req.Password, req.Code, clientInfo(c)); err != nil {
+		if refusedAttempt(c, err) {
+			return
+		}
+		switch err {
+		case myerrors.ErrAuthentication:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or code"})
+		case myerrors.ErrConflict:
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is not enabled"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// LoginHandler completes a login challenge with a code and returns the session.
+func (h *twoFactorHandler) LoginHandler(c *gin.Context) {
+	var req dto.TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("[TwoFactorLoginHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	result, err := h.twoFactorService.CompleteLogin(req.Challenge, req.Code, clientInfo(c))
+	if err != nil {
+		switch err {
+		case myerrors.ErrAuthentication:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		case myerrors.ErrRecordNotFound, myerrors.ErrExpired:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge, please log in again"})
+		case myerrors.ErrTooManyAttempts:
+			tooManyAttempts(c, result.RetryAfter)
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		}
+		return
+	}
+
+	setSessionCookie(c, h.cfg, result.SID)
+	c.JSON(http.StatusOK, gin.H{"sid": result.SID})
+}
+
+// SessionServiceHandler defines methods for session-related handlers.
+type SessionServiceHandler interface {
+	LogoutHandler(c *gin.Context)
+	LogoutAllHandler(c *gin.Context)
+	GetSessionsHandler(c *gin.Context)
+	DeleteSessionHandler(c *gin.Context)
+}
+
+// sessionHandler implements SessionServiceHandler.
+type sessionHandler struct {
+	sessionService service.SessionService
+	cfg            config.Config
+}
+
+// NewSessionHandler creates a new sessionHandler with the provided SessionService.
+func NewSessionHandler(sessionService service.SessionService, cfg config.Config) SessionServiceHandler {
+	return &sessionHandler{sessionService, cfg}
+}
+
+func (h *sessionHandler) LogoutHandler(c *gin.Context) {
+	// Extract the current session ID from the context
+	sid, _ := c.Get("sid")
+
+	// Revoke the session used to authorize this request
+	if err := h.sessionService.RevokeSession(sid.(string)); err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		}
+		return
+	}
+
+	clearSessionCookie(c, h.cfg)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+func (h *sessionHandler) LogoutAllHandler(c *gin.Context) {
+	// Extract the user ID from the context
+	userID, _ := c.Get("userId")
+
+	// Revoke every session of the authenticated user, including the current one
+	if err := h.sessionService.RevokeAllSessions(userID.(uint)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	clearSessionCookie(c, h.cfg)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions successfully"})
+}
+
+func (h *sessionHandler) GetSessionsHandler(c *gin.Context) {
+	// Extract the user ID and the current session ID from the context
+	userID, _ := c.Get("userId")
+	sid, _ := c.Get("sid")
+
+	sessions, err := h.sessionService.GetSessionsOfUser(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+
+	// Never expose the SIDs, only flag the session used for this request
+	resp := make([]dto.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		resp = append(resp, dto.SessionResponse{
+			ID:            session.ID,
+			IP:            session.IP,
+			UserAgent:     session.UserAgent,
+			CreatedAt:     session.CreatedAt,
+			LastSeenAt:    session.LastSeenAt,
+			ExpiresAt:     session.ExpiresAt,
+			IdleExpiresAt: session.IdleExpiresAt,
+			Current:       session.SID == sid.(string),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": resp})
+}
+
+func (h *sessionHandler) DeleteSessionHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	sessionID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[DeleteSessionHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session id"})
+		return
+	}
+
+	// Revoke the session only if it belongs to the authenticated user
+	if err := h.sessionService.RevokeSessionByID(userID.(uint), sessionID); err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete session"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session deleted successfully"})
+}
+
+// clientInfo extracts the details of the client making the request.
+func clientInfo(c *gin.Context) model.ClientInfo {
+	return model.ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// refusedAttempt responds with tooManyAttempts if err is a *myerrors.RetryError,
+// returning whether it did.
+func refusedAttempt(c *gin.Context, err error) bool {
+	var retry *myerrors.RetryError
+	if !errors.As(err, &retry) {
+		return false
+	}
+	tooManyAttempts(c, retry.After)
+	return true
+}
+
+// tooManyAttempts responds that the logins of the client are refused for retryAfter.
+func tooManyAttempts(c *gin.Context, retryAfter time.Duration) {
+	// Round up so that clients retrying after Retry-After are let through
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, try again later", "retry_after": seconds})
+}
+
+// setSessionCookie stores the SID in a secure HttpOnly cookie if enabled.
+func setSessionCookie(c *gin.Context, cfg config.Config, sid string) {
+	if !cfg.SessionCookieEnabled {
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(cfg.SessionCookieName, sid, int(cfg.SessionMaxLifetime.Seconds()), "/", cfg.SessionCookieDomain, cfg.SessionCookieSecure, true)
+}
+
+// clearSessionCookie removes the session cookie from the client if enabled.
+func clearSessionCookie(c *gin.Context, cfg config.Config) {
+	if !cfg.SessionCookieEnabled {
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(cfg.SessionCookieName, "", -1, "/", cfg.SessionCookieDomain, cfg.SessionCookieSecure, true)
+}
+
 // noteHandler implements NoteServiceHandler.
 type noteHandler struct {
 	noteService service.NoteService
@@ -111,17 +682,32 @@ func (h *noteHandler) CreateNoteHandler(c *gin.Context) {
 	// Create a new Note model based on the request data
 	newNote := &model.Note{
 		UserID:  userID.(uint),
+		Title:   req.Title,
 		Content: req.Note,
 	}
+	for _, name := range req.Tags {
+		newNote.Tags = append(newNote.Tags, model.Tag{Name: name})
+	}
+	if req.NotebookID != nil && *req.NotebookID != 0 {
+		newNote.NotebookID = req.NotebookID
+	}
 
 	// Call the NoteService to create the note
 	createdNote, err := h.noteService.CreateNote(newNote)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create note"})
+		switch err {
+		case myerrors.ErrInvalidInput:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tags"})
+		case myerrors.ErrNotebookNotFound:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Notebook not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create note"})
+		}
 		return
 	}
 
 	// Respond with the created note
+	c.Header("ETag", noteETag(createdNote.Version))
 	c.JSON(http.StatusOK, gin.H{"note": createdNote})
 }
 
@@ -129,36 +715,1220 @@ func (h *noteHandler) GetAllUserNotesHandler(c *gin.Context) {
 	// Extract the user ID from the context
 	userID, _ := c.Get("userId")
 
-	// Call the NoteService to get all notes for the user
-	notes, err := h.noteService.GetAllNotesOfUser(userID.(uint))
+	var query dto.ListNotesQuery
+
+	// Bind the query string to the ListNotesQuery struct
+	if err := c.ShouldBindQuery(&query); err != nil {
+		log.Println("[GetAllUserNotesHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Call the NoteService to get a page of notes for the user
+	page, err := h.noteService.GetAllNotesOfUser(userID.(uint), model.NoteQuery{
+		Limit:       query.Limit,
+		Cursor:      query.Cursor,
+		SortBy:      query.Sort,
+		Order:       query.Order,
+		From:        query.From,
+		To:          query.To,
+		Tags:        query.Tag,
+		TagMatchAny: query.TagMode == "any",
+		NotebookID:  query.NotebookID,
+		Recursive:   query.Recursive,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user notes"})
+		if err == myerrors.ErrInvalidInput {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user notes"})
+		}
 		return
 	}
 
-	// Respond with the user's notes
-	c.JSON(http.StatusOK, gin.H{"notes": notes})
+	// Respond with the page of the user's notes
+	c.JSON(http.StatusOK, page)
 }
 
-func (h *noteHandler) DeleteNoteHandler(c *gin.Context) {
-	userId, _ := c.Get("userId")
-
-	var requestBody dto.DeleteNoteRequest
+func (h *noteHandler) GetNoteHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
 
-	// Bind the request body to the CreateNoteRequest struct
-	if err := c.ShouldBindBodyWith(&requestBody, binding.JSON); err != nil {
-		log.Println("[DeleteNoteHandler] ", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[GetNoteHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
 		return
 	}
 
-	// Delete the note associated with the provided ID if it belongs to the authenticated user
-	err := h.noteService.DeleteNote(userId.(uint), uint(requestBody.ID))
+	// Notes of other users that were not shared are reported as not found
+	note, err := h.noteService.GetNoteByID(userID.(uint), noteID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete note"})
-		return
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get note"})
+		}
+		return
+	}
+
+	// Let clients revalidate their copy and base their writes on this version
+	etag := noteETag(note.Version)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"note": note})
+}
+
+func (h *noteHandler) SearchNotesHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	var query dto.SearchNotesQuery
+
+	// Bind the query string to the SearchNotesQuery struct
+	if err := c.ShouldBindQuery(&query); err != nil {
+		log.Println("[SearchNotesHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := h.noteService.SearchNotes(userID.(uint), query.Q, query.Limit, query.Offset)
+	if err != nil {
+		if err == myerrors.ErrInvalidInput {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid search query"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search notes"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+func (h *noteHandler) UpdateNoteHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[UpdateNoteHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	var req dto.UpdateNoteRequest
+
+	// Bind the request body to the UpdateNoteRequest struct
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		log.Println("[UpdateNoteHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Title == nil && req.Note == nil && req.Tags == nil && req.NotebookID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+
+	// The update must be based on the current version of the note
+	version, fromHeader, err := expectedVersion(c, req.Version)
+	if err != nil {
+		respondVersionError(c, err)
+		return
+	}
+
+	// Update the note if the authenticated user owns it or may edit it
+	updatedNote, err := h.noteService.UpdateNote(userID.(uint), noteID, model.NoteUpdate{
+		Title:      req.Title,
+		Content:    req.Note,
+		Tags:       req.Tags,
+		NotebookID: req.NotebookID,
+		Version:    version,
+	})
+	if err != nil {
+		switch err {
+		case myerrors.ErrStaleVersion:
+			h.respondStaleVersion(c, userID.(uint), noteID, fromHeader)
+		case myerrors.ErrInvalidInput:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tags"})
+		case myerrors.ErrNotebookNotFound:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Notebook not found"})
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		case myerrors.ErrUnauthorized:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update note"})
+		}
+		return
+	}
+
+	// Respond with the updated note
+	c.Header("ETag", noteETag(updatedNote.Version))
+	c.JSON(http.StatusOK, gin.H{"note": updatedNote})
+}
+
+func (h *noteHandler) DeleteNoteHandler(c *gin.Context) {
+	userId, _ := c.Get("userId")
+
+	var requestBody dto.DeleteNoteRequest
+
+	// Bind the request body to the CreateNoteRequest struct
+	if err := c.ShouldBindBodyWith(&requestBody, binding.JSON); err != nil {
+		log.Println("[DeleteNoteHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The note must not have changed since the client last saw it
+	version, fromHeader, err := expectedVersion(c, requestBody.Version)
+	if err != nil {
+		respondVersionError(c, err)
+		return
+	}
+
+	// Delete the note associated with the provided ID if the authenticated user owns it
+	err = h.noteService.DeleteNote(userId.(uint), uint(requestBody.ID), version)
+	if err != nil {
+		switch err {
+		case myerrors.ErrStaleVersion:
+			h.respondStaleVersion(c, userId.(uint), uint(requestBody.ID), fromHeader)
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		case myerrors.ErrUnauthorized:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete note"})
+		}
+		return
 	}
 
 	// Respond with a success message if the note was deleted successfully
 	c.JSON(http.StatusOK, gin.H{"message": "Note deleted successfully"})
 }
+
+func (h *noteHandler) GetTrashHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	notes, err := h.noteService.GetTrashedNotesOfUser(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notes": notes})
+}
+
+func (h *noteHandler) RestoreNoteHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[RestoreNoteHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	note, err := h.noteService.RestoreNote(userID.(uint), noteID)
+	if err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found in trash"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore note"})
+		}
+		return
+	}
+
+	c.Header("ETag", noteETag(note.Version))
+	c.JSON(http.StatusOK, gin.H{"note": note})
+}
+
+// SyncPullHandler returns the changes of the notes of the user since the cursor of
+// their last pull, including deletions, so that offline clients catch up.
+func (h *noteHandler) SyncPullHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	var query dto.SyncQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		log.Println("[SyncPullHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.noteService.GetNoteChanges(userID.(uint), query.Since, query.Limit)
+	if err != nil {
+		if err == myerrors.ErrInvalidInput {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get changes"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// SyncPushHandler applies a batch of changes made offline and reports for each
+// of them whether it was accepted, rejected or conflicts with a newer version.
+func (h *noteHandler) SyncPushHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	var req dto.SyncPushRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("[SyncPushHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make([]model.SyncItem, 0, len(req.Changes))
+	for _, change := range req.Changes {
+		items = append(items, model.SyncItem{
+			Action:     change.Action,
+			NoteID:     change.NoteID,
+			Version:    change.Version,
+			Title:      change.Title,
+			Content:    change.Note,
+			Tags:       change.Tags,
+			NotebookID: change.NotebookID,
+		})
+	}
+
+	results := h.noteService.PushNoteChanges(userID.(uint), items)
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+func (h *noteHandler) EmptyTrashHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	deleted, err := h.noteService.EmptyTrash(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to empty trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Trash emptied", "deleted": deleted})
+}
+
+func (h *noteHandler) GetRevisionsHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[GetRevisionsHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	revisions, err := h.noteService.GetRevisions(userID.(uint), noteID)
+	if err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get revisions"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+}
+
+func (h *noteHandler) GetRevisionHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[GetRevisionHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+	rev, err := uintParam(c, "rev")
+	if err != nil {
+		log.Println("[GetRevisionHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return
+	}
+
+	revision, err := h.noteService.GetRevision(userID.(uint), noteID, rev)
+	if err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get revision"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revision": revision})
+}
+
+func (h *noteHandler) DiffRevisionsHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[DiffRevisionsHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	var query dto.DiffRevisionsQuery
+
+	// Bind the query string to the DiffRevisionsQuery struct
+	if err := c.ShouldBindQuery(&query); err != nil {
+		log.Println("[DiffRevisionsHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	unified, err := h.noteService.DiffRevisions(userID.(uint), noteID, query.From, query.To)
+	if err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to diff revisions"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"from": query.From, "to": query.To, "diff": unified})
+}
+
+func (h *noteHandler) RestoreRevisionHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[RestoreRevisionHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+	rev, err := uintParam(c, "rev")
+	if err != nil {
+		log.Println("[RestoreRevisionHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return
+	}
+
+	note, err := h.noteService.RestoreRevision(userID.(uint), noteID, rev)
+	if err != nil {
+		switch err {
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		case myerrors.ErrUnauthorized:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore revision"})
+		}
+		return
+	}
+
+	c.Header("ETag", noteETag(note.Version))
+	c.JSON(http.StatusOK, gin.H{"note": note})
+}
+
+// errVersionRequired is returned by expectedVersion when the client sent no version.
+var errVersionRequired = errors.New("version required")
+
+// noteETag returns the entity tag of a version of a note.
+func noteETag(version uint) string {
+	return fmt.Sprintf("%q", strconv.FormatUint(uint64(version), 10))
+}
+
+// expectedVersion returns the version of the note a write is based on, taken from
+// the If-Match header or else from the version field of the body. fromHeader tells
+// which one was used. "If-Match: *" returns 0, which skips the version check.
+func expectedVersion(c *gin.Context, bodyVersion *uint) (version uint, fromHeader bool, err error) {
+	ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+	if ifMatch == "*" {
+		return 0, true, nil
+	}
+	if ifMatch != "" {
+		tag := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+		v, err := strconv.ParseUint(tag, 10, 32)
+		if err != nil || v == 0 {
+			return 0, true, myerrors.ErrInvalidInput
+		}
+		return uint(v), true, nil
+	}
+
+	if bodyVersion != nil && *bodyVersion != 0 {
+		return *bodyVersion, false, nil
+	}
+	return 0, false, errVersionRequired
+}
+
+// respondVersionError responds to a write whose expected version is missing or malformed.
+func respondVersionError(c *gin.Context, err error) {
+	if err == errVersionRequired {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "Send the note version in If-Match or the version field"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+}
+
+// respondStaleVersion responds to a write based on an outdated version with the
+// current copy of the note: 412 if the version came from If-Match, 409 otherwise.
+func (h *noteHandler) respondStaleVersion(c *gin.Context, userID, noteID uint, fromHeader bool) {
+	status, message := http.StatusConflict, "Version conflict"
+	if fromHeader {
+		status, message = http.StatusPreconditionFailed, "Precondition failed"
+	}
+
+	current, err := h.noteService.GetNoteByID(userID, noteID)
+	if err != nil {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	c.Header("ETag", noteETag(current.Version))
+	c.JSON(status, gin.H{"error": message, "note": current})
+}
+
+// uintParam parses the named path parameter as an unsigned ID.
+func uintParam(c *gin.Context, name string) (uint, error) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 32)
+	return uint(id), err
+}
+
+// tagHandler implements TagServiceHandler.
+type tagHandler struct {
+	tagService service.TagService
+}
+
+// NewTagHandler creates a new tagHandler with the provided TagService.
+func NewTagHandler(tagService service.TagService) TagServiceHandler {
+	return &tagHandler{tagService}
+}
+
+func (h *tagHandler) GetTagsHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	tags, err := h.tagService.GetTagsOfUser(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+func (h *tagHandler) RenameTagHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	tagID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[RenameTagHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag id"})
+		return
+	}
+
+	var req dto.RenameTagRequest
+
+	// Bind the request body to the RenameTagRequest struct
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		log.Println("[RenameTagHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tag, err := h.tagService.RenameTag(userID.(uint), tagID, req.Name)
+	if err != nil {
+		switch err {
+		case myerrors.ErrInvalidInput:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag name"})
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		case myerrors.ErrConflict:
+			c.JSON(http.StatusConflict, gin.H{"error": "A tag with this name already exists"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename tag"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tag": tag})
+}
+
+func (h *tagHandler) DeleteTagHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	tagID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[DeleteTagHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag id"})
+		return
+	}
+
+	// Deleting the tag removes it from all notes of the user
+	if err := h.tagService.DeleteTag(userID.(uint), tagID); err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tag deleted successfully"})
+}
+
+// notebookHandler implements NotebookServiceHandler.
+type notebookHandler struct {
+	notebookService service.NotebookService
+}
+
+// NewNotebookHandler creates a new notebookHandler with the provided NotebookService.
+func NewNotebookHandler(notebookService service.NotebookService) NotebookServiceHandler {
+	return &notebookHandler{notebookService}
+}
+
+func (h *notebookHandler) CreateNotebookHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	var req dto.CreateNotebookRequest
+
+	// Bind the request body to the CreateNotebookRequest struct
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		log.Println("[CreateNotebookHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	newNotebook := &model.Notebook{
+		UserID: userID.(uint),
+		Name:   req.Name,
+	}
+	if req.ParentID != nil && *req.ParentID != 0 {
+		newNotebook.ParentID = req.ParentID
+	}
+
+	notebook, err := h.notebookService.CreateNotebook(newNotebook)
+	if err != nil {
+		switch err {
+		case myerrors.ErrInvalidInput:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notebook name"})
+		case myerrors.ErrNotebookNotFound:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parent notebook not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create notebook"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notebook": notebook})
+}
+
+func (h *notebookHandler) GetNotebooksHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	notebooks, err := h.notebookService.GetNotebooksOfUser(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notebooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notebooks": notebooks})
+}
+
+func (h *notebookHandler) GetNotebookHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	notebookID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[GetNotebookHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notebook id"})
+		return
+	}
+
+	notebook, err := h.notebookService.GetNotebookByID(userID.(uint), notebookID)
+	if err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notebook not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notebook"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notebook": notebook})
+}
+
+func (h *notebookHandler) UpdateNotebookHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	notebookID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[UpdateNotebookHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notebook id"})
+		return
+	}
+
+	var req dto.UpdateNotebookRequest
+
+	// Bind the request body to the UpdateNotebookRequest struct
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		log.Println("[UpdateNotebookHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Name == nil && req.ParentID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+
+	notebook, err := h.notebookService.UpdateNotebook(userID.(uint), notebookID, model.NotebookUpdate{
+		Name:     req.Name,
+		ParentID: req.ParentID,
+	})
+	if err != nil {
+		switch err {
+		case myerrors.ErrInvalidInput:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notebook name or parent"})
+		case myerrors.ErrNotebookNotFound:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parent notebook not found"})
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notebook not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notebook"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notebook": notebook})
+}
+
+func (h *notebookHandler) DeleteNotebookHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	notebookID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[DeleteNotebookHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notebook id"})
+		return
+	}
+
+	var query dto.DeleteNotebookQuery
+
+	// Bind the query string to the DeleteNotebookQuery struct
+	if err := c.ShouldBindQuery(&query); err != nil {
+		log.Println("[DeleteNotebookHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Without an explicit mode the content is kept and moved to the parent notebook
+	cascade := query.Mode == "cascade"
+	if err := h.notebookService.DeleteNotebook(userID.(uint), notebookID, cascade); err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notebook not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete notebook"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notebook deleted successfully"})
+}
+
+// shareHandler implements ShareServiceHandler.
+type shareHandler struct {
+	shareService service.ShareService
+}
+
+// NewShareHandler creates a new shareHandler with the provided ShareService.
+func NewShareHandler(shareService service.ShareService) ShareServiceHandler {
+	return &shareHandler{shareService}
+}
+
+func (h *shareHandler) CreateShareHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[CreateShareHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	var req dto.ShareNoteRequest
+
+	// Bind the request body to the ShareNoteRequest struct
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		log.Println("[CreateShareHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Only the owner of the note may share it
+	share, err := h.shareService.ShareNote(userID.(uint), noteID, req.Email, req.Permission)
+	if err != nil {
+		switch err {
+		case myerrors.ErrInvalidInput:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Notes cannot be shared with their owner"})
+		case myerrors.ErrUserNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		case myerrors.ErrUnauthorized:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share note"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"share": shareResponse(share)})
+}
+
+func (h *shareHandler) GetSharesHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[GetSharesHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	shares, err := h.shareService.GetSharesOfNote(userID.(uint), noteID)
+	if err != nil {
+		switch err {
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		case myerrors.ErrUnauthorized:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get shares"})
+		}
+		return
+	}
+
+	resp := make([]dto.ShareResponse, 0, len(shares))
+	for _, share := range shares {
+		resp = append(resp, shareResponse(share))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": resp})
+}
+
+// shareResponse describes a share, showing only the name and email of its user.
+func shareResponse(share *model.NoteShare) dto.ShareResponse {
+	return dto.ShareResponse{
+		ID:         share.ID,
+		NoteID:     share.NoteID,
+		User:       dto.ShareUserInfo{Name: share.User.Name, Email: share.User.Email},
+		Permission: share.Permission,
+		CreatedAt:  share.CreatedAt,
+		UpdatedAt:  share.UpdatedAt,
+	}
+}
+
+func (h *shareHandler) DeleteShareHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[DeleteShareHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+	shareID, err := uintParam(c, "shareId")
+	if err != nil {
+		log.Println("[DeleteShareHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share id"})
+		return
+	}
+
+	if err := h.shareService.RevokeShare(userID.(uint), noteID, shareID); err != nil {
+		switch err {
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share not found"})
+		case myerrors.ErrUnauthorized:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share revoked"})
+}
+
+func (h *shareHandler) GetSharedWithMeHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	notes, err := h.shareService.GetNotesSharedWithUser(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get shared notes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notes": notes})
+}
+
+// publicLinkPasswordHeader carries the password of a password protected public link.
+const publicLinkPasswordHeader = "X-Link-Password"
+
+// publicLinkHandler implements PublicLinkServiceHandler.
+type publicLinkHandler struct {
+	linkService service.PublicLinkService
+}
+
+// NewPublicLinkHandler creates a new publicLinkHandler with the provided PublicLinkService.
+func NewPublicLinkHandler(linkService service.PublicLinkService) PublicLinkServiceHandler {
+	return &publicLinkHandler{linkService}
+}
+
+func (h *publicLinkHandler) CreateLinkHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[CreateLinkHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	var req dto.CreatePublicLinkRequest
+
+	// Bind the request body to the CreatePublicLinkRequest struct, an empty body uses the defaults
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil && err != io.EOF {
+		log.Println("[CreateLinkHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	link, token, err := h.linkService.CreateLink(userID.(uint), noteID, req.ExpiresAt, req.Password)
+	if err != nil {
+		switch err {
+		case myerrors.ErrInvalidInput:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		case myerrors.ErrUnauthorized:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create link"})
+		}
+		return
+	}
+
+	// The token cannot be retrieved again
+	c.JSON(http.StatusOK, gin.H{"link": link, "token": token, "path": "/v1/public/" + token})
+}
+
+func (h *publicLinkHandler) GetLinksHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[GetLinksHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	links, err := h.linkService.GetLinksOfNote(userID.(uint), noteID)
+	if err != nil {
+		switch err {
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		case myerrors.ErrUnauthorized:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get links"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"links": links})
+}
+
+func (h *publicLinkHandler) DeleteLinkHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[DeleteLinkHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+	linkID, err := uintParam(c, "linkId")
+	if err != nil {
+		log.Println("[DeleteLinkHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid link id"})
+		return
+	}
+
+	if err := h.linkService.RevokeLink(userID.(uint), noteID, linkID); err != nil {
+		switch err {
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		case myerrors.ErrUnauthorized:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke link"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Link revoked"})
+}
+
+func (h *publicLinkHandler) ViewPublicNoteHandler(c *gin.Context) {
+	note, err := h.linkService.ViewNote(c.Param("token"), c.GetHeader(publicLinkPasswordHeader), clientInfo(c))
+	if err != nil {
+		if refusedAttempt(c, err) {
+			return
+		}
+		switch err {
+		case myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Link not found"})
+		case myerrors.ErrExpired:
+			c.JSON(http.StatusGone, gin.H{"error": "Link expired"})
+		case myerrors.ErrAuthentication:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Password required in the " + publicLinkPasswordHeader + " header"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get note"})
+		}
+		return
+	}
+
+	// Only the text of the note is public, not how its owner organizes it
+	c.JSON(http.StatusOK, gin.H{"note": gin.H{
+		"title":      note.Title,
+		"note":       note.Content,
+		"created_at": note.CreatedAt,
+		"updated_at": note.UpdatedAt,
+	}})
+}
+
+// eventsHeartbeatInterval is how often an idle event stream is kept alive with a
+// comment, which is also when the session of the stream is checked again.
+const eventsHeartbeatInterval = 25 * time.Second
+
+// eventHandler implements EventServiceHandler.
+type eventHandler struct {
+	eventService   service.EventService
+	sessionService service.SessionService
+}
+
+// NewEventHandler creates a new eventHandler with the provided services.
+func NewEventHandler(eventService service.EventService, sessionService service.SessionService) EventServiceHandler {
+	return &eventHandler{eventService, sessionService}
+}
+
+// StreamEventsHandler streams the note events of the user as server-sent events.
+// Clients resume after the last event they received with the Last-Event-ID header,
+// which EventSource sends when reconnecting, or the last_event_id query parameter.
+func (h *eventHandler) StreamEventsHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	sid, _ := c.Get("sid")
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			log.Println("[StreamEventsHandler] ", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid last event id"})
+			return
+		}
+		lastID = id
+	}
+
+	events, missed, cancel, err := h.eventService.Subscribe(userID.(uint), lastID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to subscribe to events"})
+		return
+	}
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // keep proxies from buffering the stream
+	c.Status(http.StatusOK)
+
+	for _, event := range missed {
+		if err := writeEvent(c, event); err != nil {
+			return
+		}
+		lastID = event.ID
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-events:
+			// The channel closes on shutdown or if the client fell behind, it then
+			// reconnects and resumes from its last event
+			if !ok {
+				return
+			}
+			if event.ID <= lastID {
+				continue
+			}
+			if err := writeEvent(c, event); err != nil {
+				return
+			}
+			lastID = event.ID
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			// End the stream once the session was revoked or expired
+			if _, err := h.sessionService.ValidateSession(sid.(string)); err != nil {
+				return
+			}
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// writeEvent writes a note event in the server-sent events format.
+func writeEvent(c *gin.Context, event *model.NoteEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// collabMaxMessageBytes is the size limit of the messages of collaborating clients.
+const collabMaxMessageBytes = 1 << 20
+
+// collabRecheckInterval is how often the session of a collaborating client and
+// its access to the note are checked again.
+const collabRecheckInterval = 25 * time.Second
+
+// collabHandler implements CollabServiceHandler.
+type collabHandler struct {
+	collabService  service.CollabService
+	sessionService service.SessionService
+}
+
+// NewCollabHandler creates a new collabHandler with the provided CollabService,
+// checking the sessions of the clients with the SessionService.
+func NewCollabHandler(collabService service.CollabService, sessionService service.SessionService) CollabServiceHandler {
+	return &collabHandler{collabService, sessionService}
+}
+
+// CollabHandler upgrades the request to a WebSocket exchanging model.CollabMessage
+// values as JSON with a client editing a note. Reconnecting clients pass the
+// session_id and revision they last knew to catch up.
+func (h *collabHandler) CollabHandler(c *gin.Context) {
+	userID, _ := c.Get("userId")
+	sid, _ := c.Get("sid")
+
+	noteID, err := uintParam(c, "id")
+	if err != nil {
+		log.Println("[CollabHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note id"})
+		return
+	}
+
+	var query dto.CollabQuery
+
+	// Bind the query string to the CollabQuery struct
+	if err := c.ShouldBindQuery(&query); err != nil {
+		log.Println("[CollabHandler] ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Join before upgrading so that errors are reported with a status code
+	client, err := h.collabService.Join(userID.(uint), noteID, query.SessionID, query.Revision)
+	if err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join the editing session"})
+		}
+		return
+	}
+	defer client.Leave()
+
+	server := websocket.Server{
+		Handshake: checkSameOrigin,
+		Handler: func(ws *websocket.Conn) {
+			ws.MaxPayloadBytes = collabMaxMessageBytes
+			h.serveClient(ws, client, sid.(string))
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// serveClient relays the messages between a WebSocket and a collaborating client.
+// The client is dropped once its session was revoked or expired, or its user lost
+// their access to the note.
+func (h *collabHandler) serveClient(ws *websocket.Conn, client *service.CollabClient, sid string) {
+	go func() {
+		// The client left or was dropped, which also ends the reading below
+		defer ws.Close()
+
+		recheck := time.NewTicker(collabRecheckInterval)
+		defer recheck.Stop()
+
+		messages := client.Messages()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				if err := websocket.JSON.Send(ws, msg); err != nil {
+					return
+				}
+			case <-recheck.C:
+				if _, err := h.sessionService.ValidateSession(sid); err != nil {
+					client.Leave()
+					continue
+				}
+				client.Reauthorize()
+			}
+		}
+	}()
+
+	for {
+		var msg model.CollabMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			if err != io.EOF {
+				log.Println("[CollabHandler] ", err)
+			}
+			return
+		}
+		client.Submit(msg)
+	}
+}
+
+// checkSameOrigin rejects WebSocket handshakes from pages of other origins, which
+// could otherwise use the session cookie of the user. Clients that are not
+// browsers send no Origin header.
+func checkSameOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Host != req.Host {
+		return fmt.Errorf("origin %s not allowed", origin)
+	}
+	config.Origin = u
+	return nil
+}
@@ -0,0 +1,36 @@
+// Package mailer sends the emails of the application to its users.
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Mailer sends emails to users.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+type logMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+// NewLogMailer creates a Mailer writing the emails to w instead of sending them,
+// for local use and tests. Emails are written one after the other in a readable form.
+func NewLogMailer(w io.Writer, from string) Mailer {
+	return &logMailer{w: w, from: from}
+}
+
+// Send writes the email.
+func (m *logMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "From: %s\nTo: %s\nSubject: %s\nDate: %s\n\n%s\n\n",
+		m.from, to, subject, time.Now().Format(time.RFC1123Z), body)
+	return err
+}
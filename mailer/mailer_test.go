@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLogMailerWritesEmails(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf, "no-reply@example.com")
+
+	if err := m.Send("ana@example.com", "Hello", "First email"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send("bob@example.com", "Again", "Second email"); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"From: no-reply@example.com\nTo: ana@example.com\nSubject: Hello\n",
+		"\n\nFirst email\n\n",
+		"To: bob@example.com\nSubject: Again\n",
+		"\n\nSecond email\n\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q does not contain %q", out, want)
+		}
+	}
+	if strings.Index(out, "First email") > strings.Index(out, "Second email") {
+		t.Error("emails written out of order")
+	}
+}
@@ -1,13 +1,315 @@
 // model/model.go
 package model
 
-import "github.com/jinzhu/gorm"
+import (
+	"accuknox/ot"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // Note represents a note in the application.
+// The column defaults let AutoMigrate backfill notes created before the columns existed.
 type Note struct {
-	ID      uint   `json:"id, omitempty"`
+	ID      uint   `json:"id,omitempty"`
 	UserID  uint   `json:"-"`
+	Title   string `json:"title" gorm:"not null;default:''"`
 	Content string `json:"note"`
+	Version uint   `json:"version" gorm:"not null;default:1"` // incremented by every change
+	// SyncSeq orders the changes of notes for syncing clients, it is set by a database trigger.
+	SyncSeq   int64          `json:"-" gorm:"not null;default:0;index"`
+	CreatedAt time.Time      `json:"created_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time      `json:"updated_at" gorm:"not null;default:CURRENT_TIMESTAMP"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"` // set while the note is in the trash
+	Tags      []Tag          `json:"tags,omitempty" gorm:"many2many:note_tags;constraint:OnDelete:CASCADE"`
+	// NotebookID is the notebook holding the note, nil for notes outside notebooks.
+	NotebookID *uint          `json:"notebook_id" gorm:"index"`
+	Revisions  []NoteRevision `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Shares     []NoteShare    `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Links      []PublicLink   `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// NoteTombstone records a note that was permanently deleted, for syncing clients.
+// Tombstones are written by a database trigger.
+type NoteTombstone struct {
+	ID        uint
+	NoteID    uint
+	UserID    uint      `gorm:"index:idx_note_tombstones_user_seq"`
+	SyncSeq   int64     `gorm:"index:idx_note_tombstones_user_seq"`
+	DeletedAt time.Time // not a gorm.DeletedAt, tombstones are never soft deleted
+}
+
+// Types of NoteChange.
+const (
+	ChangeUpsert = "upsert"
+	ChangeDelete = "delete" // the note was moved to the trash or permanently deleted
+)
+
+// NoteChange is a change of a note returned to syncing clients.
+type NoteChange struct {
+	Type      string     `json:"type"`
+	NoteID    uint       `json:"note_id"`
+	Note      *Note      `json:"note,omitempty"` // the current note, for ChangeUpsert
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Seq       int64      `json:"-"`
+}
+
+// SyncPage is a page of note changes. Cursor is passed as since to get the next changes.
+type SyncPage struct {
+	Changes []*NoteChange `json:"changes"`
+	Cursor  string        `json:"cursor"`
+	HasMore bool          `json:"has_more"`
+	// Reset tells that the cursor was too old to know the deletions since, the
+	// changes start over with all the notes and replace those of the client.
+	Reset bool `json:"reset"`
+}
+
+// Actions of a SyncItem.
+const (
+	SyncCreate = "create"
+	SyncUpdate = "update"
+	SyncDelete = "delete"
+)
+
+// SyncItem is a change of a note made by a client while offline.
+// Updates and deletes apply only if the note is still at Version.
+type SyncItem struct {
+	Action     string
+	NoteID     uint
+	Version    uint
+	Title      *string
+	Content    *string
+	Tags       *[]string
+	NotebookID *uint
+}
+
+// Statuses of a SyncResult.
+const (
+	SyncAccepted = "accepted"
+	SyncConflict = "conflict" // the note changed since Version, Note holds the current note
+	SyncRejected = "rejected"
+)
+
+// SyncResult is the outcome of a SyncItem, in the order of the pushed items.
+type SyncResult struct {
+	Index  int    `json:"index"`
+	NoteID uint   `json:"note_id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Note   *Note  `json:"note,omitempty"`
+}
+
+// Actions recorded with a NoteRevision.
+const (
+	RevisionCreated  = "created"
+	RevisionUpdated  = "updated"
+	RevisionRestored = "restored"
+)
+
+// NoteRevision is a snapshot of the title and content of a note after a change.
+// Revisions of a note are numbered from 1.
+type NoteRevision struct {
+	ID       uint   `json:"-"`
+	NoteID   uint   `json:"note_id" gorm:"uniqueIndex:idx_note_revisions_note_revision"`
+	Revision uint   `json:"revision" gorm:"uniqueIndex:idx_note_revisions_note_revision"`
+	Title    string `json:"title"`
+	Content  string `json:"note"`
+	Action   string `json:"action"`
+	// RestoredFrom is the revision rolled back to by a RevisionRestored revision.
+	RestoredFrom *uint     `json:"restored_from,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Permissions of a user on a note. A NoteShare grants PermissionRead or PermissionEdit,
+// each permission includes the ones before it.
+const (
+	PermissionRead  = "read"
+	PermissionEdit  = "edit"
+	PermissionOwner = "owner"
+)
+
+// NoteShare grants another user access to a note, a note is shared at most once with a user.
+type NoteShare struct {
+	ID         uint      `json:"id"`
+	NoteID     uint      `json:"note_id" gorm:"uniqueIndex:idx_note_shares_note_user"`
+	UserID     uint      `json:"-" gorm:"uniqueIndex:idx_note_shares_note_user;index"`
+	User       User      `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Permission string    `json:"permission"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// SharedNote is a note shared with a user, together with its owner.
+type SharedNote struct {
+	Note
+	Permission string `json:"permission"`
+	OwnerName  string `json:"owner_name"`
+	OwnerEmail string `json:"owner_email"`
+}
+
+// PublicLink lets anyone holding its token read a note without an account.
+// Only the SHA-256 hash of the token is stored, it is shown once when the link is created.
+type PublicLink struct {
+	ID           uint       `json:"id"`
+	NoteID       uint       `json:"note_id" gorm:"index"`
+	TokenHash    string     `json:"-" gorm:"uniqueIndex"`
+	PasswordHash string     `json:"-"`          // bcrypt hash, empty for links without a password
+	ExpiresAt    *time.Time `json:"expires_at"` // nil for links that never expire
+	ViewCount    int64      `json:"view_count" gorm:"not null;default:0"`
+	LastViewedAt *time.Time `json:"last_viewed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	// PasswordProtected tells whether the link requires a password, it is not stored.
+	PasswordProtected bool `json:"password_protected" gorm:"-"`
+}
+
+// AfterFind fills the fields of a link that are derived from the stored ones.
+func (l *PublicLink) AfterFind(tx *gorm.DB) error {
+	l.PasswordProtected = l.PasswordHash != ""
+	return nil
+}
+
+// Types of NoteEvent.
+const (
+	EventNoteCreated = "note.created"
+	EventNoteUpdated = "note.updated"
+	EventNoteDeleted = "note.deleted"
+)
+
+// NoteEvent tells the users having access to a note that it changed.
+// Event IDs increase with every event, across all users.
+type NoteEvent struct {
+	ID        uint64    `json:"id"`
+	Type      string    `json:"type"`
+	NoteID    uint      `json:"note_id"`
+	Version   uint      `json:"version"`
+	Note      *Note     `json:"note,omitempty"` // the changed note, nil for deleted notes
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Types of CollabMessage.
+const (
+	CollabInit  = "init"   // state of the session sent to a client that joined
+	CollabOp    = "op"     // operation on the content of the note
+	CollabAck   = "ack"    // the operation of the client was applied
+	CollabMoved = "cursor" // the cursor of a client moved
+	CollabJoin  = "join"   // a client joined the session
+	CollabLeave = "leave"  // a client left the session
+	CollabError = "error"  // a message of the client was rejected
+)
+
+// CollabMessage is a message exchanged with the clients editing a note together.
+// Revision counts the operations applied in the session: clients send the revision
+// their operation is based on, the server sends the revision reached by an operation.
+type CollabMessage struct {
+	Type      string         `json:"type"`
+	SessionID string         `json:"session_id,omitempty"`
+	ClientID  string         `json:"client_id,omitempty"` // empty for operations merged by the server
+	Name      string         `json:"name,omitempty"`
+	Revision  int            `json:"revision"`
+	OpID      string         `json:"op_id,omitempty"` // chosen by the client to recognize its operations
+	Op        ot.Op          `json:"op,omitempty"`
+	Content   *string        `json:"content,omitempty"`
+	Cursor    *CollabCursor  `json:"cursor,omitempty"`
+	Clients   []Collaborator `json:"clients,omitempty"`
+	ReadOnly  bool           `json:"read_only,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// CollabCursor is the cursor and selection of a client, as positions in the content.
+type CollabCursor struct {
+	Position     int `json:"position"`
+	SelectionEnd int `json:"selection_end"`
+}
+
+// Collaborator is a client taking part in a collaborative editing session.
+type Collaborator struct {
+	ClientID string        `json:"client_id"`
+	Name     string        `json:"name"`
+	ReadOnly bool          `json:"read_only,omitempty"`
+	Cursor   *CollabCursor `json:"cursor,omitempty"`
+}
+
+// Notebook groups notes, notebooks nest through their ParentID.
+type Notebook struct {
+	ID        uint      `json:"id"`
+	UserID    uint      `json:"-" gorm:"index"`
+	ParentID  *uint     `json:"parent_id" gorm:"index"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NotebookUpdate holds the fields of a partial notebook update, nil fields are left unchanged.
+type NotebookUpdate struct {
+	Name     *string
+	ParentID *uint // 0 moves the notebook to the top level
+}
+
+// Tag is a label a user attaches to notes, tag names are unique per user.
+type Tag struct {
+	ID     uint   `json:"id"`
+	UserID uint   `json:"-" gorm:"uniqueIndex:idx_tags_user_name"`
+	Name   string `json:"name" gorm:"uniqueIndex:idx_tags_user_name"`
+}
+
+// TagUsage is a tag with the number of notes it is attached to.
+type TagUsage struct {
+	Tag
+	NoteCount int64 `json:"note_count"`
+}
+
+// NoteUpdate holds the fields of a partial note update, nil fields are left unchanged.
+type NoteUpdate struct {
+	Title   *string
+	Content *string
+	Tags    *[]string // replaces all tags of the note
+	// NotebookID moves the note to another notebook, 0 moves it out of notebooks.
+	NotebookID *uint
+	// Version is the version the update is based on, 0 skips the version check.
+	Version uint
+	// Amend replaces the last revision of the note instead of adding one, for a
+	// writer saving often whose previous update, at Version, recorded it.
+	Amend bool
+}
+
+// Sort fields and orders of a NoteQuery.
+const (
+	NoteSortCreatedAt = "created_at"
+	NoteSortUpdatedAt = "updated_at"
+
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// NoteQuery describes a page of notes to list.
+type NoteQuery struct {
+	Limit  int
+	Cursor string // opaque cursor returned with the previous page
+	SortBy string // NoteSortCreatedAt or NoteSortUpdatedAt
+	Order  string // SortAsc or SortDesc
+	// From and To restrict the notes to a range of the sort field, zero values are ignored.
+	From time.Time
+	To   time.Time
+	// Tags restricts the notes to those having all the tags, or any of them if TagMatchAny is set.
+	Tags        []string
+	TagMatchAny bool
+	// NotebookID restricts the notes to a notebook, including its nested notebooks if Recursive is set.
+	NotebookID *uint
+	Recursive  bool
+}
+
+// NotePage is a page of notes returned for a NoteQuery.
+type NotePage struct {
+	Notes      []*Note `json:"notes"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	Total      int64   `json:"total"`
+}
+
+// NoteSearchResult is a note matching a full-text search.
+type NoteSearchResult struct {
+	Note
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"` // matches are wrapped in <mark> tags
 }
 
 // User represents a user in the application.
@@ -16,11 +318,110 @@ type User struct {
 	Name         string `json:"name"`
 	Email        string `json:"email" gorm:"uniqueIndex"`
 	PasswordHash string `json:"-"`
+	// EmailVerified is set once the user followed the link sent to their email.
+	EmailVerified bool `json:"email_verified" gorm:"not null;default:false"`
+	// PendingEmail is the email the user asked to change to, it replaces Email
+	// once the link sent to it is followed.
+	PendingEmail string `json:"pending_email,omitempty" gorm:"not null;default:''"`
+	// TOTPSecret is the encrypted secret of two-factor authentication, set from its
+	// setup on. TwoFactorEnabled is only set once the user confirmed it with a code.
+	TOTPSecret       string `json:"-"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled" gorm:"not null;default:false"`
+	// TOTPLastStep is the time step of the last code used, codes are accepted once.
+	TOTPLastStep int64 `json:"-" gorm:"not null;default:0"`
+}
+
+// UserUpdate holds the fields of a partial profile update, nil fields are left unchanged.
+type UserUpdate struct {
+	Name  *string
+	Email *string // a new email is pending until verified, the current one cancels the change
+}
+
+// Scopes of a LoginLockout.
+const (
+	LockoutEmail = "email"
+	LockoutIP    = "ip"
+)
+
+// LoginLockout records that the logins of an email or an IP were locked after
+// too many failures.
+type LoginLockout struct {
+	ID          uint      `json:"id"`
+	Scope       string    `json:"scope"`
+	Subject     string    `json:"subject" gorm:"index"` // the email or the IP that was locked
+	IP          string    `json:"ip"`                   // the IP of the last failed login
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RecoveryCode is a one-time code logging a user in when they lost their
+// authenticator. Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint
+	UserID    uint `gorm:"index"`
+	User      User `gorm:"constraint:OnDelete:CASCADE"`
+	CodeHash  string
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// TwoFactorSetup is the secret an authenticator app is set up with, also as an
+// otpauth URI to show as a QR code.
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// LoginResult is the outcome of a login with a password: a session, or a
+// challenge to answer with a code when two-factor authentication is enabled.
+type LoginResult struct {
+	SID       string
+	Challenge string
+	// RetryAfter is how long a client refused with myerrors.ErrTooManyAttempts waits.
+	RetryAfter time.Duration
+}
+
+// Purposes of a UserToken.
+const (
+	TokenPasswordReset     = "password_reset"
+	TokenEmailVerification = "email_verification"
+	TokenLoginChallenge    = "login_challenge" // a login waiting for its two-factor code
+	TokenEmailChange       = "email_change"    // sent to the pending email of the user
+)
+
+// UserToken is a single-use token sent to a user by email to prove they own
+// their address. Only the hash of the token is stored.
+type UserToken struct {
+	ID        uint
+	UserID    uint `gorm:"index"`
+	User      User `gorm:"constraint:OnDelete:CASCADE"`
+	Purpose   string
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	// Attempts counts the wrong codes entered with a login challenge.
+	Attempts  int `gorm:"not null;default:0"`
+	CreatedAt time.Time
 }
 
 // UserSession represents a user session with a unique session ID (sid).
+// Sessions are kept in a session store together with the client that created them.
 type UserSession struct {
-	gorm.Model
-	UserID uint   `json:"user_id"`
-	SID    string `json:"sid" redis:"column:sid"`
+	ID         uint      `json:"id"`
+	UserID     uint      `json:"-"`
+	SID        string    `json:"-"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// IdleExpiresAt is moved forward every time the session is used.
+	IdleExpiresAt time.Time `json:"idle_expires_at"`
+}
+
+// ClientInfo describes the client a session is created for.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
 }
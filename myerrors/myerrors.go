@@ -1,14 +1,40 @@
 // myapp/myerrors/myerrors.go
 package myerrors
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 // Define custom errors as variables or constants.
 var (
-	ErrRecordNotFound = errors.New("record not found")
-	ErrInvalidInput   = errors.New("invalid input")
-	ErrAuthentication = errors.New("authentication failed")
-	ErrUnauthorized   = errors.New("unauthorized")
-	ErrInternalServer = errors.New("internal server error")
+	ErrRecordNotFound  = errors.New("record not found")
+	ErrInvalidInput    = errors.New("invalid input")
+	ErrAuthentication  = errors.New("authentication failed")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrSessionExpired  = errors.New("session expired")
+	ErrExpired         = errors.New("expired")
+	ErrConflict        = errors.New("conflict")
+	ErrStaleVersion    = errors.New("stale version")
+	ErrInternalServer  = errors.New("internal server error")
+	ErrNotConfigured   = errors.New("not configured")
+	ErrTooManyAttempts = errors.New("too many attempts")
+
+	ErrNotebookNotFound = errors.New("notebook not found")
+	ErrUserNotFound     = errors.New("user not found")
 	// Add more custom errors as needed
 )
+
+// RetryError refuses an attempt that is throttled, telling how long to wait before
+// trying again. It unwraps to ErrTooManyAttempts.
+type RetryError struct {
+	After time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return ErrTooManyAttempts.Error()
+}
+
+func (e *RetryError) Unwrap() error {
+	return ErrTooManyAttempts
+}
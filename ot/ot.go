@@ -0,0 +1,347 @@
+// Package ot implements operational transformation of plain text, so that
+// concurrent edits of a text converge whatever order they are applied in.
+//
+// An Op walks the whole text from start to end, retaining, inserting or deleting
+// characters. Lengths and positions count Unicode code points. In JSON an Op is
+// an array where a positive number retains characters, a negative number deletes
+// characters and a string inserts it, e.g. [3, "abc", -2, 5].
+package ot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// ErrLength is returned when an operation does not fit the length of the text.
+var ErrLength = errors.New("ot: operation length does not match the text")
+
+// maxComponent is the largest number of characters a decoded component may
+// retain or delete, far beyond any note.
+const maxComponent = math.MaxInt32
+
+// Component is a step of an Op, exactly one of its fields is set.
+type Component struct {
+	Retain int
+	Insert string
+	Delete int
+}
+
+// Op is an edit of a whole text. Ops are built with Retain, Insert and Delete,
+// which may reuse the array of the op they extend, like append.
+type Op []Component
+
+// Retain appends the retaining of n characters to the operation.
+func (o Op) Retain(n int) Op {
+	if n <= 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].Retain > 0 {
+		o[last].Retain += n
+		return o
+	}
+	return append(o, Component{Retain: n})
+}
+
+// Insert appends the insertion of s to the operation. Inserts are kept before
+// deletes at the same position so that equal edits have a single form.
+func (o Op) Insert(s string) Op {
+	if s == "" {
+		return o
+	}
+	last := len(o) - 1
+	if last >= 0 && o[last].Insert != "" {
+		o[last].Insert += s
+		return o
+	}
+	if last >= 0 && o[last].Delete > 0 {
+		if last > 0 && o[last-1].Insert != "" {
+			o[last-1].Insert += s
+			return o
+		}
+		o = append(o, o[last])
+		o[last] = Component{Insert: s}
+		return o
+	}
+	return append(o, Component{Insert: s})
+}
+
+// Delete appends the deletion of n characters to the operation.
+func (o Op) Delete(n int) Op {
+	if n <= 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].Delete > 0 {
+		o[last].Delete += n
+		return o
+	}
+	return append(o, Component{Delete: n})
+}
+
+// BaseLen returns the length of the texts the operation applies to. It returns
+// ErrLength if the length does not fit an int.
+func (o Op) BaseLen() (int, error) {
+	n := 0
+	for _, c := range o {
+		var ok bool
+		if n, ok = addLen(n, c.Retain); !ok {
+			return 0, ErrLength
+		}
+		if n, ok = addLen(n, c.Delete); !ok {
+			return 0, ErrLength
+		}
+	}
+	return n, nil
+}
+
+// TargetLen returns the length of the texts the operation produces. It returns
+// ErrLength if the length does not fit an int.
+func (o Op) TargetLen() (int, error) {
+	n := 0
+	for _, c := range o {
+		var ok bool
+		if n, ok = addLen(n, c.Retain); !ok {
+			return 0, ErrLength
+		}
+		if n, ok = addLen(n, utf8.RuneCountInString(c.Insert)); !ok {
+			return 0, ErrLength
+		}
+	}
+	return n, nil
+}
+
+// addLen adds a length m to n, reporting false for negative lengths and overflows.
+func addLen(n, m int) (int, bool) {
+	if m < 0 || n > math.MaxInt-m {
+		return 0, false
+	}
+	return n + m, true
+}
+
+// IsNoop tells whether the operation leaves the text unchanged.
+func (o Op) IsNoop() bool {
+	for _, c := range o {
+		if c.Retain == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply applies the operation to the text.
+func Apply(text string, o Op) (string, error) {
+	runes := []rune(text)
+	if n, err := o.BaseLen(); err != nil || n != len(runes) {
+		return "", ErrLength
+	}
+	target, err := o.TargetLen()
+	if err != nil {
+		return "", err
+	}
+
+	out := make([]rune, 0, target)
+	pos := 0
+	for _, c := range o {
+		switch {
+		case c.Retain > 0:
+			out = append(out, runes[pos:pos+c.Retain]...)
+			pos += c.Retain
+		case c.Insert != "":
+			out = append(out, []rune(c.Insert)...)
+		default:
+			pos += c.Delete
+		}
+	}
+	return string(out), nil
+}
+
+// Transform transforms two operations applying to the same text into a' and b'
+// such that applying a then b' gives the same text as applying b then a'.
+// When both insert at the same position, the text inserted by a comes first.
+func Transform(a, b Op) (Op, Op, error) {
+	aLen, err := a.BaseLen()
+	if err != nil {
+		return nil, nil, err
+	}
+	if bLen, err := b.BaseLen(); err != nil || aLen != bLen {
+		return nil, nil, ErrLength
+	}
+
+	var aPrime, bPrime Op
+	ca, cb := components(a), components(b)
+	x, y := ca.next(), cb.next()
+	for x != nil || y != nil {
+		// Inserts do not depend on the other operation
+		if x != nil && x.Insert != "" {
+			aPrime = aPrime.Insert(x.Insert)
+			bPrime = bPrime.Retain(utf8.RuneCountInString(x.Insert))
+			x = ca.next()
+			continue
+		}
+		if y != nil && y.Insert != "" {
+			aPrime = aPrime.Retain(utf8.RuneCountInString(y.Insert))
+			bPrime = bPrime.Insert(y.Insert)
+			y = cb.next()
+			continue
+		}
+		if x == nil || y == nil {
+			return nil, nil, ErrLength
+		}
+
+		// Both components cover characters of the text, consume the shorter one
+		n := x.size()
+		if m := y.size(); m < n {
+			n = m
+		}
+		switch {
+		case x.Retain > 0 && y.Retain > 0:
+			aPrime = aPrime.Retain(n)
+			bPrime = bPrime.Retain(n)
+		case x.Delete > 0 && y.Retain > 0:
+			aPrime = aPrime.Delete(n)
+		case x.Retain > 0 && y.Delete > 0:
+			bPrime = bPrime.Delete(n)
+		}
+		// Characters deleted by both are simply gone
+
+		x = ca.consume(x, n)
+		y = cb.consume(y, n)
+	}
+
+	return aPrime, bPrime, nil
+}
+
+// TransformIndex moves a position in a text to where it is after the operation.
+// Text inserted at the position pushes it forward.
+func TransformIndex(index int, o Op) int {
+	newIndex := index
+	for _, c := range o {
+		switch {
+		case c.Retain > 0:
+			index -= c.Retain
+		case c.Insert != "":
+			newIndex += utf8.RuneCountInString(c.Insert)
+		default:
+			if index < c.Delete {
+				newIndex -= index
+			} else {
+				newIndex -= c.Delete
+			}
+			index -= c.Delete
+		}
+		if index < 0 {
+			break
+		}
+	}
+	return newIndex
+}
+
+// Replace returns an operation turning from into to by replacing the part between
+// their common prefix and suffix.
+func Replace(from, to string) Op {
+	a, b := []rune(from), []rune(to)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var o Op
+	o = o.Retain(prefix)
+	o = o.Insert(string(b[prefix : len(b)-suffix]))
+	o = o.Delete(len(a) - prefix - suffix)
+	return o.Retain(suffix)
+}
+
+// MarshalJSON encodes the operation in its compact array form.
+func (o Op) MarshalJSON() ([]byte, error) {
+	items := make([]interface{}, len(o))
+	for i, c := range o {
+		switch {
+		case c.Retain > 0:
+			items[i] = c.Retain
+		case c.Insert != "":
+			items[i] = c.Insert
+		default:
+			items[i] = -c.Delete
+		}
+	}
+	return json.Marshal(items)
+}
+
+// UnmarshalJSON decodes an operation from its compact array form.
+func (o *Op) UnmarshalJSON(data []byte) error {
+	var items []interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+
+	var op Op
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			op = op.Insert(v)
+		case float64:
+			if math.Abs(v) > maxComponent {
+				return fmt.Errorf("ot: component %v too large", v)
+			}
+			n := int(v)
+			if float64(n) != v || n == 0 {
+				return fmt.Errorf("ot: invalid component %v", v)
+			}
+			if n > 0 {
+				op = op.Retain(n)
+			} else {
+				op = op.Delete(-n)
+			}
+		default:
+			return fmt.Errorf("ot: invalid component %v", v)
+		}
+	}
+	*o = op
+	return nil
+}
+
+// size returns the number of characters of the text a component covers.
+func (c *Component) size() int {
+	return c.Retain + c.Delete
+}
+
+// componentIter walks the components of an operation, splitting them as needed.
+type componentIter struct {
+	op Op
+	i  int
+}
+
+func components(o Op) *componentIter {
+	return &componentIter{op: o}
+}
+
+// next returns a copy of the next component, nil at the end.
+func (it *componentIter) next() *Component {
+	if it.i >= len(it.op) {
+		return nil
+	}
+	c := it.op[it.i]
+	it.i++
+	return &c
+}
+
+// consume takes n characters off a component, returning what is left of it or the next one.
+func (it *componentIter) consume(c *Component, n int) *Component {
+	if c.size() == n {
+		return it.next()
+	}
+	if c.Retain > 0 {
+		c.Retain -= n
+	} else {
+		c.Delete -= n
+	}
+	return c
+}
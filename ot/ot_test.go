@@ -0,0 +1,148 @@
+package ot
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	op := Op{}.Retain(6).Insert("brave ").Delete(5).Insert("new").Retain(6)
+	got, err := Apply("hello world, hi", op)
+	if err == nil {
+		t.Fatalf("Apply = %q, want a length error", got)
+	}
+
+	op = Op{}.Retain(6).Insert("brave new").Delete(5).Retain(4)
+	got, err = Apply("hello world, hi", op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello brave new, hi"; got != want {
+		t.Errorf("Apply = %q, want %q", got, want)
+	}
+}
+
+func TestTransformConverges(t *testing.T) {
+	text := "the quick fox"
+	tests := []struct {
+		name string
+		a, b Op
+		want string
+	}{
+		{
+			name: "inserts at different positions",
+			a:    Op{}.Retain(4).Insert("very ").Retain(9),
+			b:    Op{}.Retain(10).Insert("brown ").Retain(3),
+			want: "the very quick brown fox",
+		},
+		{
+			name: "inserts at the same position keep a first",
+			a:    Op{}.Retain(13).Insert("!"),
+			b:    Op{}.Retain(13).Insert("?"),
+			want: "the quick fox!?",
+		},
+		{
+			name: "overlapping deletes",
+			a:    Op{}.Retain(4).Delete(6).Retain(3),
+			b:    Op{}.Retain(8).Delete(5),
+			want: "the ",
+		},
+		{
+			name: "insert inside a deleted range",
+			a:    Op{}.Retain(4).Delete(6).Retain(3),
+			b:    Op{}.Retain(6).Insert("ii").Retain(7),
+			want: "the iifox",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aPrime, bPrime, err := Transform(tt.a, tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			afterA, _ := Apply(text, tt.a)
+			left, err := Apply(afterA, bPrime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			afterB, _ := Apply(text, tt.b)
+			right, err := Apply(afterB, aPrime)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if left != right || left != tt.want {
+				t.Errorf("a then b' = %q, b then a' = %q, want %q", left, right, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformIndex(t *testing.T) {
+	op := Op{}.Retain(2).Insert("xyz").Retain(3).Delete(4).Retain(1)
+	for index, want := range map[int]int{0: 0, 2: 5, 4: 7, 7: 8, 10: 9} {
+		if got := TransformIndex(index, op); got != want {
+			t.Errorf("TransformIndex(%d) = %d, want %d", index, got, want)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	from, to := "héllo wörld", "héllo brave wörld"
+	got, err := Apply(from, Replace(from, to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != to {
+		t.Errorf("Apply(Replace) = %q, want %q", got, to)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var op Op
+	if err := json.Unmarshal([]byte(`[3, "abc", -2, 5]`), &op); err != nil {
+		t.Fatal(err)
+	}
+	base, _ := op.BaseLen()
+	target, _ := op.TargetLen()
+	if base != 10 || target != 11 {
+		t.Errorf("BaseLen = %d, TargetLen = %d", base, target)
+	}
+
+	data, err := json.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[3,"abc",-2,5]`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+
+	if err := json.Unmarshal([]byte(`[1.5]`), &op); err == nil {
+		t.Error("fractional component accepted")
+	}
+}
+
+func TestLengthOverflow(t *testing.T) {
+	var op Op
+	if err := json.Unmarshal([]byte(`[4611686018427387904,-4611686018427387904,4611686018427387904,-4611686018427387904]`), &op); err == nil {
+		t.Error("oversized components accepted")
+	}
+	if err := json.Unmarshal([]byte(`[2147483648]`), &op); err == nil {
+		t.Error("component above MaxInt32 accepted")
+	}
+
+	// Ops built in code are not bounded, their lengths must not wrap around
+	op = Op{{Retain: math.MaxInt}, {Delete: math.MaxInt}, {Retain: 2}}
+	if _, err := op.BaseLen(); err != ErrLength {
+		t.Errorf("BaseLen error = %v, want ErrLength", err)
+	}
+	if _, err := Apply("", op); err != ErrLength {
+		t.Errorf("Apply error = %v, want ErrLength", err)
+	}
+	if _, _, err := Transform(op, Op{}); err != ErrLength {
+		t.Errorf("Transform error = %v, want ErrLength", err)
+	}
+}
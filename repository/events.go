@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"accuknox/model"
+	"sync"
+)
+
+// eventBacklogSize is the number of events kept per user for clients resuming their stream.
+const eventBacklogSize = 1000
+
+// eventSubscriberBuffer is the number of events a subscriber may fall behind
+// before it is dropped, it then resumes from its last event.
+const eventSubscriberBuffer = 64
+
+// eventSubscribers delivers events to the subscribers of this instance.
+type eventSubscribers struct {
+	mu     sync.Mutex
+	byUser map[uint]map[chan *model.NoteEvent]struct{}
+	closed bool
+}
+
+func newEventSubscribers() *eventSubscribers {
+	return &eventSubscribers{byUser: make(map[uint]map[chan *model.NoteEvent]struct{})}
+}
+
+// add subscribes to the events of a user.
+func (s *eventSubscribers) add(userID uint) (<-chan *model.NoteEvent, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch := make(chan *model.NoteEvent, eventSubscriberBuffer)
+	if s.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if s.byUser[userID] == nil {
+		s.byUser[userID] = make(map[chan *model.NoteEvent]struct{})
+	}
+	s.byUser[userID][ch] = struct{}{}
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.remove(userID, ch)
+	}
+}
+
+// deliver sends an event to the subscribers of the given users. Subscribers
+// that cannot keep up are dropped rather than blocking the others.
+func (s *eventSubscribers) deliver(event *model.NoteEvent, userIDs []uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, userID := range userIDs {
+		for ch := range s.byUser[userID] {
+			select {
+			case ch <- event:
+			default:
+				s.remove(userID, ch)
+			}
+		}
+	}
+}
+
+// closeAll drops every subscriber, for shutdown.
+func (s *eventSubscribers) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for userID, chans := range s.byUser {
+		for ch := range chans {
+			s.remove(userID, ch)
+		}
+	}
+	s.closed = true
+}
+
+// remove closes the channel of a subscriber, it must be called with the lock held.
+func (s *eventSubscribers) remove(userID uint, ch chan *model.NoteEvent) {
+	if _, ok := s.byUser[userID][ch]; !ok {
+		return
+	}
+	delete(s.byUser[userID], ch)
+	if len(s.byUser[userID]) == 0 {
+		delete(s.byUser, userID)
+	}
+	close(ch)
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"accuknox/model"
+	"sync"
+	"time"
+)
+
+type memoryEventBus struct {
+	mu          sync.Mutex
+	lastID      uint64
+	backlog     map[uint][]*model.NoteEvent
+	retention   time.Duration
+	subscribers *eventSubscribers
+	now         func() time.Time
+}
+
+// NewMemoryEventBus creates a new EventBus delivering events within this process.
+// It is meant for local development and tests, as clients connected to other
+// instances do not receive the events.
+func NewMemoryEventBus(retention time.Duration) EventBus {
+	return &memoryEventBus{
+		backlog:     make(map[uint][]*model.NoteEvent),
+		retention:   retention,
+		subscribers: newEventSubscribers(),
+		now:         time.Now,
+	}
+}
+
+// Publish assigns the ID of the event and delivers it to the given users.
+func (b *memoryEventBus) Publish(event *model.NoteEvent, userIDs []uint) error {
+	b.mu.Lock()
+	b.lastID++
+	event.ID = b.lastID
+	for _, userID := range userIDs {
+		events := append(b.backlog[userID], event)
+		if len(events) > eventBacklogSize {
+			events = events[len(events)-eventBacklogSize:]
+		}
+		b.backlog[userID] = events
+	}
+	b.mu.Unlock()
+
+	b.subscribers.deliver(event, userIDs)
+	return nil
+}
+
+// Since retrieves the events of a user that came after the event with the given ID.
+func (b *memoryEventBus) Since(userID uint, lastID uint64) ([]*model.NoteEvent, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cutoff := b.now().Add(-b.retention)
+	var events []*model.NoteEvent
+	for _, event := range b.backlog[userID] {
+		if event.ID > lastID && event.CreatedAt.After(cutoff) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Subscribe returns the channel receiving the events of a user from now on.
+func (b *memoryEventBus) Subscribe(userID uint) (<-chan *model.NoteEvent, func()) {
+	return b.subscribers.add(userID)
+}
+
+// Close drops every subscriber.
+func (b *memoryEventBus) Close() error {
+	b.subscribers.closeAll()
+	return nil
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"accuknox/model"
+	"testing"
+	"time"
+)
+
+func TestMemoryEventBusDeliversAndResumes(t *testing.T) {
+	bus := NewMemoryEventBus(time.Hour)
+	defer bus.Close()
+
+	events, cancel := bus.Subscribe(2)
+	defer cancel()
+
+	first := &model.NoteEvent{Type: model.EventNoteCreated, NoteID: 1, CreatedAt: time.Now()}
+	second := &model.NoteEvent{Type: model.EventNoteUpdated, NoteID: 1, CreatedAt: time.Now()}
+	if err := bus.Publish(first, []uint{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Publish(second, []uint{1}); err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == 0 || second.ID <= first.ID {
+		t.Fatalf("event IDs not increasing: %d, %d", first.ID, second.ID)
+	}
+
+	// Only the events of the subscribed user are delivered
+	select {
+	case got := <-events:
+		if got.ID != first.ID {
+			t.Errorf("received event %d, want %d", got.ID, first.ID)
+		}
+	default:
+		t.Fatal("no event delivered")
+	}
+	select {
+	case got := <-events:
+		t.Errorf("received event %d of another user", got.ID)
+	default:
+	}
+
+	missed, err := bus.Since(1, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missed) != 1 || missed[0].ID != second.ID {
+		t.Errorf("Since = %v, want event %d only", missed, second.ID)
+	}
+}
+
+func TestMemoryEventBusDropsSlowSubscribers(t *testing.T) {
+	bus := NewMemoryEventBus(time.Hour)
+	defer bus.Close()
+
+	events, cancel := bus.Subscribe(1)
+	defer cancel()
+
+	for i := 0; i <= eventSubscriberBuffer; i++ {
+		bus.Publish(&model.NoteEvent{Type: model.EventNoteUpdated, CreatedAt: time.Now()}, []uint{1})
+	}
+
+	// The buffered events are still received, then the channel is closed
+	received := 0
+	for range events {
+		received++
+	}
+	if received != eventSubscriberBuffer {
+		t.Errorf("received %d events, want %d", received, eventSubscriberBuffer)
+	}
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// eventSeqKey is the redis key of the counter used to number events.
+const eventSeqKey = "event_seq"
+
+// eventChannel is the redis channel events are fanned out on to all instances.
+const eventChannel = "note_events"
+
+// eventMessage is an event published on eventChannel with the users it is for.
+type eventMessage struct {
+	UserIDs []uint           `json:"user_ids"`
+	Event   *model.NoteEvent `json:"event"`
+}
+
+type redisEventBus struct {
+	rClient     *redis.Client
+	pubsub      *redis.PubSub
+	retention   time.Duration
+	subscribers *eventSubscribers
+}
+
+// NewRedisEventBus creates a new EventBus fanning events out to all instances
+// through redis pub/sub. The events of each user are also kept in a sorted set
+// for retention so that clients can resume their stream on any instance.
+func NewRedisEventBus(rClient *redis.Client, retention time.Duration) EventBus {
+	b := &redisEventBus{
+		rClient:     rClient,
+		pubsub:      rClient.Subscribe(eventChannel),
+		retention:   retention,
+		subscribers: newEventSubscribers(),
+	}
+	go b.listen()
+	return b
+}
+
+// listen delivers the events published by all instances to the local subscribers.
+func (b *redisEventBus) listen() {
+	for msg := range b.pubsub.Channel() {
+		var message eventMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+			log.Println("[EventBus:listen] ", err)
+			continue
+		}
+		b.subscribers.deliver(message.Event, message.UserIDs)
+	}
+}
+
+// Publish assigns the ID of the event and delivers it to the given users.
+func (b *redisEventBus) Publish(event *model.NoteEvent, userIDs []uint) error {
+	id, err := b.rClient.Incr(eventSeqKey).Result()
+	if err != nil {
+		log.Println("[EventBus:Publish] ", err)
+		return myerrors.ErrInternalServer
+	}
+	event.ID = uint64(id)
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Println("[EventBus:Publish] ", err)
+		return myerrors.ErrInternalServer
+	}
+	message, err := json.Marshal(eventMessage{UserIDs: userIDs, Event: event})
+	if err != nil {
+		log.Println("[EventBus:Publish] ", err)
+		return myerrors.ErrInternalServer
+	}
+
+	// Keep the event for resuming streams, then fan it out
+	pipe := b.rClient.TxPipeline()
+	for _, userID := range userIDs {
+		key := userEventsKey(userID)
+		pipe.ZAdd(key, redis.Z{Score: float64(event.ID), Member: data})
+		pipe.ZRemRangeByRank(key, 0, -eventBacklogSize-1)
+		pipe.Expire(key, b.retention)
+	}
+	pipe.Publish(eventChannel, message)
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("[EventBus:Publish] ", err)
+		return myerrors.ErrInternalServer
+	}
+
+	return nil
+}
+
+// Since retrieves the events of a user that came after the event with the given ID.
+func (b *redisEventBus) Since(userID uint, lastID uint64) ([]*model.NoteEvent, error) {
+	members, err := b.rClient.ZRangeByScore(userEventsKey(userID), redis.ZRangeBy{
+		Min: "(" + strconv.FormatUint(lastID, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		log.Println("[EventBus:Since] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	cutoff := time.Now().Add(-b.retention)
+	events := make([]*model.NoteEvent, 0, len(members))
+	for _, member := range members {
+		var event model.NoteEvent
+		if err := json.Unmarshal([]byte(member), &event); err != nil {
+			log.Println("[EventBus:Since] ", err)
+			return nil, myerrors.ErrInternalServer
+		}
+		if event.CreatedAt.After(cutoff) {
+			events = append(events, &event)
+		}
+	}
+
+	return events, nil
+}
+
+// Subscribe returns the channel receiving the events of a user from now on.
+func (b *redisEventBus) Subscribe(userID uint) (<-chan *model.NoteEvent, func()) {
+	return b.subscribers.add(userID)
+}
+
+// Close stops listening to redis and drops every subscriber.
+func (b *redisEventBus) Close() error {
+	err := b.pubsub.Close()
+	b.subscribers.closeAll()
+	return err
+}
+
+// userEventsKey returns the redis key of the sorted set holding the recent events of a user.
+func userEventsKey(userID uint) string {
+	return fmt.Sprintf("user_events:%d", userID)
+}
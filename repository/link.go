@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type publicLinkRepository struct {
+	db *gorm.DB
+}
+
+// NewPublicLinkRepository creates a new PublicLinkRepository with the given database connection.
+func NewPublicLinkRepository(db *gorm.DB) PublicLinkRepository {
+	return &publicLinkRepository{db}
+}
+
+// CreateLink creates a new public link.
+func (r *publicLinkRepository) CreateLink(link *model.PublicLink) (*model.PublicLink, error) {
+	if err := r.db.Create(link).Error; err != nil {
+		log.Println("[Repo:CreateLink] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	link.PasswordProtected = link.PasswordHash != ""
+	return link, nil
+}
+
+// GetLinksOfNote retrieves the public links of a note, most recent first.
+func (r *publicLinkRepository) GetLinksOfNote(noteID uint) ([]*model.PublicLink, error) {
+	var links []*model.PublicLink
+
+	if err := r.db.Where("note_id = ?", noteID).Order("created_at DESC, id DESC").Find(&links).Error; err != nil {
+		log.Println("[Repo:GetLinksOfNote] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return links, nil
+}
+
+// GetLinkByTokenHash retrieves a public link by the hash of its token.
+func (r *publicLinkRepository) GetLinkByTokenHash(tokenHash string) (*model.PublicLink, error) {
+	var link model.PublicLink
+	if err := r.db.Where("token_hash = ?", tokenHash).First(&link).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, myerrors.ErrRecordNotFound
+		}
+
+		log.Println("[Repo:GetLinkByTokenHash] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+	return &link, nil
+}
+
+// DeleteLink revokes a public link of a note.
+func (r *publicLinkRepository) DeleteLink(noteID, linkID uint) error {
+	result := r.db.Where("id = ? AND note_id = ?", linkID, noteID).Delete(&model.PublicLink{})
+
+	if result.Error != nil {
+		log.Println("[Repo:DeleteLink] ", result.Error)
+		return myerrors.ErrInternalServer
+	}
+	if result.RowsAffected == 0 {
+		return myerrors.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RecordView counts a view of a public link.
+func (r *publicLinkRepository) RecordView(linkID uint) error {
+	result := r.db.Model(&model.PublicLink{}).Where("id = ?", linkID).Updates(map[string]interface{}{
+		"view_count":     gorm.Expr("view_count + 1"),
+		"last_viewed_at": time.Now(),
+	})
+
+	if result.Error != nil {
+		log.Println("[Repo:RecordView] ", result.Error)
+		return myerrors.ErrInternalServer
+	}
+
+	return nil
+}
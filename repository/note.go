@@ -3,11 +3,91 @@ package repository
 import (
 	"accuknox/model"
 	"accuknox/myerrors"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"log"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// noteSearchMigrations add the full-text search column of notes, which AutoMigrate
+// cannot create as it is generated from the title and the content.
+var noteSearchMigrations = []string{
+	`ALTER TABLE notes ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(content, '')), 'B')
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_notes_search_vector ON notes USING GIN (search_vector)`,
+}
+
+// noteSearchQuery parses the search text the way web search engines do.
+const noteSearchQuery = "websearch_to_tsquery('english', ?)"
+
+// noteHeadlineOptions configures the snippets returned with search results.
+const noteHeadlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10"
+
+// MigrateNoteSearch creates the full-text search column and index of notes.
+// It must run after the notes table was migrated.
+func MigrateNoteSearch(db *gorm.DB) error {
+	for _, stmt := range noteSearchMigrations {
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Println("[Repo:MigrateNoteSearch] ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// noteSyncMigrations number every change of a note from a single sequence and
+// record a tombstone when a note is permanently deleted, so that syncing clients
+// can fetch the changes since the last one they saw. The changes of a user are
+// serialized with an advisory lock, so that they commit in the order of the sequence
+// and a client never skips a change that was still being committed.
+var noteSyncMigrations = []string{
+	`CREATE SEQUENCE IF NOT EXISTS notes_sync_seq`,
+	`CREATE OR REPLACE FUNCTION notes_set_sync_seq() RETURNS trigger AS $$
+	BEGIN
+		PERFORM pg_advisory_xact_lock(hashtext('notes_sync'), NEW.user_id::int);
+		NEW.sync_seq := nextval('notes_sync_seq');
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS notes_sync_seq ON notes`,
+	`CREATE TRIGGER notes_sync_seq BEFORE INSERT OR UPDATE ON notes
+		FOR EACH ROW EXECUTE PROCEDURE notes_set_sync_seq()`,
+	`CREATE OR REPLACE FUNCTION notes_record_tombstone() RETURNS trigger AS $$
+	BEGIN
+		PERFORM pg_advisory_xact_lock(hashtext('notes_sync'), OLD.user_id::int);
+		INSERT INTO note_tombstones (note_id, user_id, sync_seq, deleted_at)
+			VALUES (OLD.id, OLD.user_id, nextval('notes_sync_seq'), now());
+		RETURN OLD;
+	END;
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS notes_tombstone ON notes`,
+	`CREATE TRIGGER notes_tombstone AFTER DELETE ON notes
+		FOR EACH ROW EXECUTE PROCEDURE notes_record_tombstone()`,
+	// Number the notes created before the trigger existed
+	`UPDATE notes SET sync_seq = 0 WHERE sync_seq = 0`,
+}
+
+// MigrateNoteSync creates the sequence and triggers numbering the changes of notes.
+// It must run after the notes and note_tombstones tables were migrated.
+func MigrateNoteSync(db *gorm.DB) error {
+	// Replace the triggers in a transaction so that no change goes unnumbered
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range noteSyncMigrations {
+			if err := tx.Exec(stmt).Error; err != nil {
+				log.Println("[Repo:MigrateNoteSync] ", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 type noteRepository struct {
 	db *gorm.DB
 }
@@ -18,12 +98,38 @@ func NewNoteRepository(db *gorm.DB) NoteRepository {
 }
 
 func (r *noteRepository) CreateNote(note *model.Note) (*model.Note, error) {
-	// Use GORM's Create method to insert the note into the database
-	result := r.db.Create(note)
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if note.NotebookID != nil {
+			if err := checkNotebookOwner(tx, note.UserID, *note.NotebookID); err != nil {
+				return err
+			}
+		}
+
+		// Attach the existing tags of the user, creating the missing ones
+		names := make([]string, 0, len(note.Tags))
+		for _, tag := range note.Tags {
+			names = append(names, tag.Name)
+		}
+		tags, err := resolveTags(tx, note.UserID, names)
+		if err != nil {
+			return err
+		}
+		note.Tags = tags
+
+		// Use GORM's Create method to insert the note into the database
+		if err := tx.Create(note).Error; err != nil {
+			return err
+		}
+
+		return addRevision(tx, note, model.RevisionCreated, nil)
+	})
 
 	// Check for errors during the creation process
-	if result.Error != nil {
-		log.Println("[Repo:CreateNote] ", result.Error)
+	if err != nil {
+		if err == myerrors.ErrNotebookNotFound {
+			return nil, err
+		}
+		log.Println("[Repo:CreateNote] ", err)
 		return nil, myerrors.ErrInternalServer
 	}
 
@@ -31,34 +137,248 @@ func (r *noteRepository) CreateNote(note *model.Note) (*model.Note, error) {
 	return note, nil
 }
 
-// GetNoteByID retrieves a note by its ID.
-func (r *noteRepository) GetNoteByID(id uint) (*model.Note, error) {
-	// Implement the GetNoteByID method
-	return nil, nil
+// GetNoteByID retrieves a note by its ID, scoped to the given user so that
+// notes of other users are reported as not found.
+func (r *noteRepository) GetNoteByID(userID, noteID uint) (*model.Note, error) {
+	var note model.Note
+	if err := r.db.Preload("Tags").Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:GetNoteByID] ", err)
+			return nil, myerrors.ErrRecordNotFound // Note not found
+		}
+
+		log.Println("[Repo:GetNoteByID] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+	return &note, nil
 }
 
-// GetAllNotesOfUser retrieves all notes of a user by their UserID.
-func (r *noteRepository) GetAllNotesOfUser(userID uint) ([]*model.Note, error) {
-	// Create a slice to hold the retrieved notes
+// FindNoteByID retrieves a note by its ID whoever owns it, leaving the access
+// checks to the caller. Notes in the trash are reported as not found.
+func (r *noteRepository) FindNoteByID(noteID uint) (*model.Note, error) {
+	var note model.Note
+	if err := r.db.Preload("Tags").First(&note, noteID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:FindNoteByID] ", err)
+			return nil, myerrors.ErrRecordNotFound
+		}
+
+		log.Println("[Repo:FindNoteByID] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+	return &note, nil
+}
+
+// GetAllNotesOfUser retrieves a page of notes of a user by their UserID.
+// Pages are chained with a keyset cursor so that deep pages stay cheap.
+func (r *noteRepository) GetAllNotesOfUser(userID uint, query model.NoteQuery) (*model.NotePage, error) {
+	if query.SortBy != model.NoteSortCreatedAt && query.SortBy != model.NoteSortUpdatedAt {
+		return nil, myerrors.ErrInvalidInput
+	}
+	if query.Order != model.SortAsc && query.Order != model.SortDesc {
+		return nil, myerrors.ErrInvalidInput
+	}
+
+	// filter selects the notes of the query regardless of the page
+	filter := func(db *gorm.DB) *gorm.DB {
+		db = db.Where("user_id = ?", userID)
+		if !query.From.IsZero() {
+			db = db.Where(query.SortBy+" >= ?", query.From)
+		}
+		if !query.To.IsZero() {
+			db = db.Where(query.SortBy+" <= ?", query.To)
+		}
+		if len(query.Tags) > 0 {
+			tagged := r.db.Table("note_tags").
+				Select("note_tags.note_id").
+				Joins("JOIN tags ON tags.id = note_tags.tag_id").
+				Where("tags.user_id = ? AND tags.name IN ?", userID, query.Tags)
+			if !query.TagMatchAny {
+				// The note must carry every one of the tags
+				tagged = tagged.Group("note_tags.note_id").Having("COUNT(DISTINCT tags.name) = ?", len(query.Tags))
+			}
+			db = db.Where("id IN (?)", tagged)
+		}
+		if query.NotebookID != nil {
+			if query.Recursive {
+				db = db.Where("notebook_id IN (?)", r.db.Raw(notebookTreeQuery, *query.NotebookID, userID))
+			} else {
+				db = db.Where("notebook_id = ?", *query.NotebookID)
+			}
+		}
+		return db
+	}
+
+	var total int64
+	if err := r.db.Model(&model.Note{}).Scopes(filter).Count(&total).Error; err != nil {
+		log.Println("[Repo:GetAllNotesOfUser] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	db := r.db.Scopes(filter)
+	if query.Cursor != "" {
+		cursor, err := decodeNoteCursor(query.Cursor)
+		if err != nil || cursor.SortBy != query.SortBy || cursor.Order != query.Order {
+			log.Println("[Repo:GetAllNotesOfUser] invalid cursor ", err)
+			return nil, myerrors.ErrInvalidInput
+		}
+
+		// Continue strictly after the last note of the previous page, using the ID to break ties
+		cmp := "<"
+		if query.Order == model.SortAsc {
+			cmp = ">"
+		}
+		db = db.Where(fmt.Sprintf("(%s, id) %s (?, ?)", query.SortBy, cmp), cursor.Value, cursor.ID)
+	}
+
+	// Fetch one extra note to know whether there is a next page
 	var notes []*model.Note
+	order := fmt.Sprintf("%s %s, id %s", query.SortBy, query.Order, query.Order)
+	if err := db.Preload("Tags").Order(order).Limit(query.Limit + 1).Find(&notes).Error; err != nil {
+		log.Println("[Repo:GetAllNotesOfUser] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	page := &model.NotePage{Notes: notes, Total: total}
+	if len(notes) > query.Limit {
+		page.Notes = notes[:query.Limit]
+		last := page.Notes[query.Limit-1]
 
-	// Use GORM's Find method to retrieve all notes for the specified userID
-	result := r.db.Where("user_id = ?", userID).Find(&notes)
+		cursor := noteCursor{SortBy: query.SortBy, Order: query.Order, Value: last.CreatedAt, ID: last.ID}
+		if query.SortBy == model.NoteSortUpdatedAt {
+			cursor.Value = last.UpdatedAt
+		}
+		page.NextCursor = encodeNoteCursor(cursor)
+	}
+
+	return page, nil
+}
+
+// SearchNotes retrieves the notes of a user matching the search text, best matches first.
+func (r *noteRepository) SearchNotes(userID uint, text string, limit, offset int) ([]*model.NoteSearchResult, error) {
+	var results []*model.NoteSearchResult
+
+	selectQuery := fmt.Sprintf(
+		"notes.*, ts_rank(search_vector, %s) AS rank, ts_headline('english', content, %s, ?) AS snippet",
+		noteSearchQuery, noteSearchQuery,
+	)
+	result := r.db.Model(&model.Note{}).
+		Select(selectQuery, text, text, noteHeadlineOptions).
+		Where("user_id = ?", userID).
+		Where("search_vector @@ "+noteSearchQuery, text).
+		Order("rank DESC, id DESC").
+		Limit(limit).
+		Offset(offset).
+		Scan(&results)
 
-	// Check for errors during the retrieval process
 	if result.Error != nil {
-		log.Println("[Repo:GetAllNotesOfUser] ", result.Error)
-		return nil, result.Error
+		log.Println("[Repo:SearchNotes] ", result.Error)
+		return nil, myerrors.ErrInternalServer
 	}
 
-	// Return the retrieved notes
-	return notes, nil
+	return results, nil
+}
+
+// UpdateNote applies a partial update to a note owned by the given user.
+// It returns myerrors.ErrRecordNotFound if the note does not exist,
+// myerrors.ErrUnauthorized if it belongs to another user and
+// myerrors.ErrStaleVersion if update.Version is set but is not the current version.
+func (r *noteRepository) UpdateNote(userID, noteID uint, update model.NoteUpdate) (*model.Note, error) {
+	var note model.Note
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the note so that concurrent updates are applied one after the other
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&note, noteID).Error; err != nil {
+			return err
+		}
+
+		if note.UserID != userID {
+			return myerrors.ErrUnauthorized
+		}
+		if update.Version != 0 && update.Version != note.Version {
+			return myerrors.ErrStaleVersion
+		}
+		previous := note
+
+		// Only update the fields that were provided
+		fields := map[string]interface{}{}
+		if update.Title != nil {
+			fields["title"] = *update.Title
+		}
+		if update.Content != nil {
+			fields["content"] = *update.Content
+		}
+
+		if update.NotebookID != nil {
+			if *update.NotebookID == 0 {
+				fields["notebook_id"] = nil
+			} else {
+				if err := checkNotebookOwner(tx, userID, *update.NotebookID); err != nil {
+					return err
+				}
+				fields["notebook_id"] = *update.NotebookID
+			}
+		}
+
+		if update.Tags != nil {
+			tags, err := resolveTags(tx, userID, *update.Tags)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&note).Association("Tags").Replace(tags); err != nil {
+				return err
+			}
+			fields["updated_at"] = time.Now()
+		} else if err := tx.Model(&note).Association("Tags").Find(&note.Tags); err != nil {
+			return err
+		}
+
+		if len(fields) == 0 {
+			return nil
+		}
+		fields["version"] = note.Version + 1
+
+		// Updates also sets updated_at
+		if err := tx.Model(&note).Omit("Tags").Updates(fields).Error; err != nil {
+			return err
+		}
+
+		// Record a revision if the text of the note changed
+		if note.Title == previous.Title && note.Content == previous.Content {
+			return nil
+		}
+		if err := ensureBaseRevision(tx, &previous); err != nil {
+			return err
+		}
+		if update.Amend {
+			return amendRevision(tx, &note)
+		}
+		return addRevision(tx, &note, model.RevisionUpdated, nil)
+	})
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:UpdateNote] ", err)
+			return nil, myerrors.ErrRecordNotFound
+		}
+		if err == myerrors.ErrUnauthorized || err == myerrors.ErrNotebookNotFound || err == myerrors.ErrStaleVersion {
+			return nil, err
+		}
+		log.Println("[Repo:UpdateNote] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return &note, nil
 }
 
-// DeleteNote deletes a note by its ID for a specific user.
-func (r *noteRepository) DeleteNote(userID, noteID uint) error {
-	// Use GORM's Delete method to delete the note
-	result := r.db.Where("id = ? AND user_id = ?", noteID, userID).Delete(&model.Note{})
+// DeleteNote moves a note of a specific user to the trash. If version is not 0,
+// the note is only deleted at that version and myerrors.ErrStaleVersion is returned otherwise.
+func (r *noteRepository) DeleteNote(userID, noteID, version uint) error {
+	// GORM's Delete only sets deleted_at as the note has a gorm.DeletedAt field
+	db := r.db.Where("id = ? AND user_id = ?", noteID, userID)
+	if version != 0 {
+		db = db.Where("version = ?", version)
+	}
+	result := db.Delete(&model.Note{})
 
 	// Check for errors during the deletion process
 	if result.Error != nil {
@@ -66,11 +386,271 @@ func (r *noteRepository) DeleteNote(userID, noteID uint) error {
 		return result.Error
 	}
 
-	// Check if any record was deleted (0 records deleted means note not found)
+	// Check if any record was deleted (0 records deleted means note not found or changed)
 	if result.RowsAffected == 0 {
+		if version != 0 {
+			if _, err := r.GetNoteByID(userID, noteID); err == nil {
+				return myerrors.ErrStaleVersion
+			}
+		}
 		log.Println("[Repo:DeleteNote] ", result.Error)
 		return myerrors.ErrRecordNotFound
 	}
 
 	return nil // Note deleted successfully
 }
+
+// GetTrashedNotesOfUser retrieves the notes of a user that are in the trash, most recently deleted first.
+func (r *noteRepository) GetTrashedNotesOfUser(userID uint) ([]*model.Note, error) {
+	var notes []*model.Note
+
+	result := r.db.Unscoped().Preload("Tags").
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC, id DESC").
+		Find(&notes)
+
+	if result.Error != nil {
+		log.Println("[Repo:GetTrashedNotesOfUser] ", result.Error)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return notes, nil
+}
+
+// RestoreNote moves a note of the user out of the trash. If its notebook was
+// deleted in the meantime, the note is restored outside of notebooks.
+func (r *noteRepository) RestoreNote(userID, noteID uint) (*model.Note, error) {
+	var note model.Note
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", noteID, userID).First(&note).Error; err != nil {
+			return err
+		}
+
+		fields := map[string]interface{}{"deleted_at": nil, "version": note.Version + 1}
+		if note.NotebookID != nil {
+			if err := checkNotebookOwner(tx, userID, *note.NotebookID); err == myerrors.ErrNotebookNotFound {
+				fields["notebook_id"] = nil
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Unscoped().Model(&note).Updates(fields).Error; err != nil {
+			return err
+		}
+		return tx.Model(&note).Association("Tags").Find(&note.Tags)
+	})
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:RestoreNote] ", err)
+			return nil, myerrors.ErrRecordNotFound
+		}
+		log.Println("[Repo:RestoreNote] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return &note, nil
+}
+
+// EmptyTrash permanently deletes all notes of the user that are in the trash.
+func (r *noteRepository) EmptyTrash(userID uint) (int64, error) {
+	result := r.db.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).Delete(&model.Note{})
+
+	if result.Error != nil {
+		log.Println("[Repo:EmptyTrash] ", result.Error)
+		return 0, myerrors.ErrInternalServer
+	}
+
+	return result.RowsAffected, nil
+}
+
+// PurgeTrashedNotes permanently deletes the notes of all users that were moved
+// to the trash before the given time.
+func (r *noteRepository) PurgeTrashedNotes(before time.Time) (int64, error) {
+	result := r.db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", before).Delete(&model.Note{})
+
+	if result.Error != nil {
+		log.Println("[Repo:PurgeTrashedNotes] ", result.Error)
+		return 0, myerrors.ErrInternalServer
+	}
+
+	return result.RowsAffected, nil
+}
+
+// PurgeTombstones deletes the records of the notes permanently deleted before the
+// given time. Clients that last synced before then have to sync all over again.
+func (r *noteRepository) PurgeTombstones(before time.Time) (int64, error) {
+	result := r.db.Where("deleted_at < ?", before).Delete(&model.NoteTombstone{})
+
+	if result.Error != nil {
+		log.Println("[Repo:PurgeTombstones] ", result.Error)
+		return 0, myerrors.ErrInternalServer
+	}
+
+	return result.RowsAffected, nil
+}
+
+// GetNoteChanges retrieves the changes of the notes of a user made after the
+// given cursor, in order. Moving a note to the trash and deleting it permanently
+// are both reported as deletions. Without a cursor the current notes are returned
+// without deletions, for the first sync of a client. Cursors issued before
+// prunedBefore may miss purged tombstones, the changes then start over as without
+// a cursor and the page is marked as a reset.
+func (r *noteRepository) GetNoteChanges(userID uint, cursor string, limit int, prunedBefore time.Time) (*model.SyncPage, error) {
+	var since int64
+	reset := false
+	if cursor != "" {
+		var issuedAt time.Time
+		var err error
+		if since, issuedAt, err = decodeSyncCursor(cursor); err != nil {
+			log.Println("[Repo:GetNoteChanges] invalid cursor ", err)
+			return nil, myerrors.ErrInvalidInput
+		}
+		if since > 0 && issuedAt.Before(prunedBefore) {
+			since = 0
+			reset = true
+		}
+	}
+
+	// Fetch one extra change to know whether there are more
+	var notes []*model.Note
+	db := r.db.Unscoped().Preload("Tags").Where("user_id = ? AND sync_seq > ?", userID, since)
+	if since == 0 {
+		db = db.Where("deleted_at IS NULL")
+	}
+	if err := db.Order("sync_seq").Limit(limit + 1).Find(&notes).Error; err != nil {
+		log.Println("[Repo:GetNoteChanges] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	var tombstones []*model.NoteTombstone
+	if since > 0 {
+		result := r.db.Where("user_id = ? AND sync_seq > ?", userID, since).
+			Order("sync_seq").
+			Limit(limit + 1).
+			Find(&tombstones)
+		if result.Error != nil {
+			log.Println("[Repo:GetNoteChanges] ", result.Error)
+			return nil, myerrors.ErrInternalServer
+		}
+	}
+
+	// Merge both lists in the order of the sequence
+	changes := make([]*model.NoteChange, 0, len(notes)+len(tombstones))
+	for len(notes) > 0 || len(tombstones) > 0 {
+		if len(tombstones) == 0 || (len(notes) > 0 && notes[0].SyncSeq < tombstones[0].SyncSeq) {
+			changes = append(changes, noteChange(notes[0]))
+			notes = notes[1:]
+		} else {
+			deletedAt := tombstones[0].DeletedAt
+			changes = append(changes, &model.NoteChange{
+				Type:      model.ChangeDelete,
+				NoteID:    tombstones[0].NoteID,
+				DeletedAt: &deletedAt,
+				Seq:       tombstones[0].SyncSeq,
+			})
+			tombstones = tombstones[1:]
+		}
+	}
+
+	// Cursors carry the time they were issued at, a client syncing regularly never
+	// falls behind the purge of the tombstones
+	now := time.Now()
+	page := &model.SyncPage{Changes: changes, Cursor: encodeSyncCursor(since, now), Reset: reset}
+	if len(changes) > limit {
+		page.Changes = changes[:limit]
+		page.HasMore = true
+	}
+	if n := len(page.Changes); n > 0 {
+		page.Cursor = encodeSyncCursor(page.Changes[n-1].Seq, now)
+	}
+
+	return page, nil
+}
+
+// updateNotes applies the fields to notes changed in bulk, such as by renaming one
+// of their tags, bumping their version so that sync clients fetch them again, and
+// returns them as changed. Notes in the trash are left alone.
+func updateNotes(tx *gorm.DB, noteIDs []uint, fields map[string]interface{}) ([]*model.Note, error) {
+	notes := []*model.Note{}
+	if len(noteIDs) == 0 {
+		return notes, nil
+	}
+
+	fields["version"] = gorm.Expr("version + 1")
+	// Updates also sets updated_at
+	if err := tx.Model(&model.Note{}).Where("id IN ?", noteIDs).Updates(fields).Error; err != nil {
+		return nil, err
+	}
+	// Unscoped as the fields may move the notes to the trash
+	if err := tx.Unscoped().Preload("Tags").Where("id IN ?", noteIDs).Order("id").Find(&notes).Error; err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
+
+// noteChange returns the change of a note at its current state.
+func noteChange(note *model.Note) *model.NoteChange {
+	if note.DeletedAt.Valid {
+		deletedAt := note.DeletedAt.Time
+		return &model.NoteChange{Type: model.ChangeDelete, NoteID: note.ID, DeletedAt: &deletedAt, Seq: note.SyncSeq}
+	}
+	return &model.NoteChange{Type: model.ChangeUpsert, NoteID: note.ID, Note: note, Seq: note.SyncSeq}
+}
+
+// syncCursor is the sync sequence of the last change a client received and the
+// time it was received at, in Unix seconds.
+type syncCursor struct {
+	Seq int64 `json:"seq"`
+	At  int64 `json:"at"`
+}
+
+// encodeSyncCursor turns a sync sequence and the time it is sent at into an opaque cursor.
+func encodeSyncCursor(seq int64, at time.Time) string {
+	data, _ := json.Marshal(syncCursor{Seq: seq, At: at.Unix()})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSyncCursor parses a cursor returned by encodeSyncCursor. Cursors issued
+// before they carried a time are dated at the Unix epoch.
+func decodeSyncCursor(s string) (int64, time.Time, error) {
+	var cursor syncCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return 0, time.Time{}, err
+	}
+	if cursor.Seq < 0 {
+		return 0, time.Time{}, fmt.Errorf("negative sync sequence %d", cursor.Seq)
+	}
+	return cursor.Seq, time.Unix(cursor.At, 0), nil
+}
+
+// noteCursor is the position of the last note of a page.
+type noteCursor struct {
+	SortBy string    `json:"s"`
+	Order  string    `json:"o"`
+	Value  time.Time `json:"v"`
+	ID     uint      `json:"id"`
+}
+
+// encodeNoteCursor turns a cursor into an opaque string.
+func encodeNoteCursor(cursor noteCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeNoteCursor parses a cursor returned by encodeNoteCursor.
+func decodeNoteCursor(s string) (noteCursor, error) {
+	var cursor noteCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// notebookTreeQuery selects the ID of a notebook of the user and of all notebooks nested in it.
+const notebookTreeQuery = `WITH RECURSIVE tree AS (
+	SELECT id FROM notebooks WHERE id = ? AND user_id = ?
+	UNION
+	SELECT notebooks.id FROM notebooks JOIN tree ON notebooks.parent_id = tree.id
+) SELECT id FROM tree`
+
+type notebookRepository struct {
+	db *gorm.DB
+}
+
+// NewNotebookRepository creates a new NotebookRepository with the given database connection.
+func NewNotebookRepository(db *gorm.DB) NotebookRepository {
+	return &notebookRepository{db}
+}
+
+// CreateNotebook creates a new notebook, nested in notebook.ParentID if set.
+func (r *notebookRepository) CreateNotebook(notebook *model.Notebook) (*model.Notebook, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if notebook.ParentID != nil {
+			if err := checkNotebookOwner(tx, notebook.UserID, *notebook.ParentID); err != nil {
+				return err
+			}
+		}
+		return tx.Create(notebook).Error
+	})
+
+	if err != nil {
+		if err == myerrors.ErrNotebookNotFound {
+			return nil, err
+		}
+		log.Println("[Repo:CreateNotebook] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return notebook, nil
+}
+
+// GetNotebooksOfUser retrieves all notebooks of a user.
+func (r *notebookRepository) GetNotebooksOfUser(userID uint) ([]*model.Notebook, error) {
+	var notebooks []*model.Notebook
+
+	if err := r.db.Where("user_id = ?", userID).Order("name, id").Find(&notebooks).Error; err != nil {
+		log.Println("[Repo:GetNotebooksOfUser] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return notebooks, nil
+}
+
+// GetNotebookByID retrieves a notebook of the user by its ID.
+func (r *notebookRepository) GetNotebookByID(userID, notebookID uint) (*model.Notebook, error) {
+	var notebook model.Notebook
+	if err := r.db.Where("id = ? AND user_id = ?", notebookID, userID).First(&notebook).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:GetNotebookByID] ", err)
+			return nil, myerrors.ErrRecordNotFound
+		}
+
+		log.Println("[Repo:GetNotebookByID] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+	return &notebook, nil
+}
+
+// UpdateNotebook renames and/or moves a notebook of the user.
+// Moving a notebook into itself or one of its nested notebooks is rejected with
+// myerrors.ErrInvalidInput.
+func (r *notebookRepository) UpdateNotebook(userID, notebookID uint, update model.NotebookUpdate) (*model.Notebook, error) {
+	var notebook model.Notebook
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", notebookID, userID).First(&notebook).Error; err != nil {
+			return err
+		}
+
+		fields := map[string]interface{}{}
+		if update.Name != nil {
+			fields["name"] = *update.Name
+		}
+		if update.ParentID != nil {
+			if *update.ParentID == 0 {
+				fields["parent_id"] = nil
+			} else {
+				if err := checkNotebookOwner(tx, userID, *update.ParentID); err != nil {
+					return err
+				}
+
+				// The new parent must not be inside the notebook, concurrent moves
+				// could otherwise each pass the check and make a cycle together
+				if err := lockNotebooks(tx, userID); err != nil {
+					return err
+				}
+				var tree []uint
+				if err := tx.Raw(notebookTreeQuery, notebookID, userID).Scan(&tree).Error; err != nil {
+					return err
+				}
+				for _, id := range tree {
+					if id == *update.ParentID {
+						return myerrors.ErrInvalidInput
+					}
+				}
+				fields["parent_id"] = *update.ParentID
+			}
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+
+		return tx.Model(&notebook).Updates(fields).Error
+	})
+
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			log.Println("[Repo:UpdateNotebook] ", err)
+			return nil, myerrors.ErrRecordNotFound
+		case myerrors.ErrNotebookNotFound, myerrors.ErrInvalidInput:
+			return nil, err
+		default:
+			log.Println("[Repo:UpdateNotebook] ", err)
+			return nil, myerrors.ErrInternalServer
+		}
+	}
+
+	return &notebook, nil
+}
+
+// DeleteNotebook deletes a notebook of the user. With cascade, its nested notebooks
+// are deleted too and all their notes are moved to the trash, otherwise they move to
+// the parent of the notebook. It returns the notes it trashed or moved.
+func (r *notebookRepository) DeleteNotebook(userID, notebookID uint, cascade bool) ([]*model.Note, error) {
+	var notes []*model.Note
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var notebook model.Notebook
+		if err := tx.Where("id = ? AND user_id = ?", notebookID, userID).First(&notebook).Error; err != nil {
+			return err
+		}
+
+		if err := lockNotebooks(tx, userID); err != nil {
+			return err
+		}
+
+		if cascade {
+			var tree []uint
+			if err := tx.Raw(notebookTreeQuery, notebookID, userID).Scan(&tree).Error; err != nil {
+				return err
+			}
+			var noteIDs []uint
+			if err := tx.Model(&model.Note{}).Where("user_id = ? AND notebook_id IN ?", userID, tree).Pluck("id", &noteIDs).Error; err != nil {
+				return err
+			}
+			// Set deleted_at by hand as Delete would not bump the version
+			var err error
+			if notes, err = updateNotes(tx, noteIDs, map[string]interface{}{"deleted_at": time.Now()}); err != nil {
+				return err
+			}
+			return tx.Where("user_id = ? AND id IN ?", userID, tree).Delete(&model.Notebook{}).Error
+		}
+
+		// Hand the content of the notebook over to its parent
+		var noteIDs []uint
+		if err := tx.Model(&model.Note{}).Where("user_id = ? AND notebook_id = ?", userID, notebookID).Pluck("id", &noteIDs).Error; err != nil {
+			return err
+		}
+		var err error
+		if notes, err = updateNotes(tx, noteIDs, map[string]interface{}{"notebook_id": notebook.ParentID}); err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Notebook{}).Where("user_id = ? AND parent_id = ?", userID, notebookID).
+			Update("parent_id", notebook.ParentID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&notebook).Error
+	})
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:DeleteNotebook] ", err)
+			return nil, myerrors.ErrRecordNotFound
+		}
+		log.Println("[Repo:DeleteNotebook] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return notes, nil
+}
+
+// lockNotebooks serializes the changes of the tree of the notebooks of a user
+// until the end of the transaction.
+func lockNotebooks(tx *gorm.DB, userID uint) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext('notebooks'), ?::int)", userID).Error
+}
+
+// checkNotebookOwner returns myerrors.ErrNotebookNotFound unless the notebook belongs to the user.
+func checkNotebookOwner(tx *gorm.DB, userID, notebookID uint) error {
+	var count int64
+	if err := tx.Model(&model.Notebook{}).Where("id = ? AND user_id = ?", notebookID, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return myerrors.ErrNotebookNotFound
+	}
+	return nil
+}
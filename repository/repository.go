@@ -3,23 +3,128 @@ package repository
 
 import (
 	"accuknox/model"
+	"time"
 )
 
 // NoteRepository defines methods for managing notes.
 type NoteRepository interface {
 	CreateNote(note *model.Note) (*model.Note, error)
-	GetNoteByID(id uint) (*model.Note, error)
-	GetAllNotesOfUser(userID uint) ([]*model.Note, error)
-	DeleteNote(userId, noteId uint) error
+	GetNoteByID(userID, noteID uint) (*model.Note, error)
+	FindNoteByID(noteID uint) (*model.Note, error)
+	GetAllNotesOfUser(userID uint, query model.NoteQuery) (*model.NotePage, error)
+	SearchNotes(userID uint, text string, limit, offset int) ([]*model.NoteSearchResult, error)
+	UpdateNote(userID, noteID uint, update model.NoteUpdate) (*model.Note, error)
+	DeleteNote(userId, noteId, version uint) error
+	GetTrashedNotesOfUser(userID uint) ([]*model.Note, error)
+	RestoreNote(userID, noteID uint) (*model.Note, error)
+	EmptyTrash(userID uint) (int64, error)
+	PurgeTrashedNotes(before time.Time) (int64, error)
+	GetRevisions(userID, noteID uint) ([]*model.NoteRevision, error)
+	GetRevision(userID, noteID, revision uint) (*model.NoteRevision, error)
+	RestoreRevision(userID, noteID, revision uint) (*model.Note, error)
+	GetNoteChanges(userID uint, cursor string, limit int, prunedBefore time.Time) (*model.SyncPage, error)
+	PurgeTombstones(before time.Time) (int64, error)
 	// Add more note-related methods here
 }
 
+// TagRepository defines methods for managing the tags of notes.
+type TagRepository interface {
+	GetTagsOfUser(userID uint) ([]*model.TagUsage, error)
+	RenameTag(userID, tagID uint, name string) (*model.Tag, []*model.Note, error)
+	DeleteTag(userID, tagID uint) ([]*model.Note, error)
+}
+
+// NotebookRepository defines methods for managing notebooks.
+type NotebookRepository interface {
+	CreateNotebook(notebook *model.Notebook) (*model.Notebook, error)
+	GetNotebooksOfUser(userID uint) ([]*model.Notebook, error)
+	GetNotebookByID(userID, notebookID uint) (*model.Notebook, error)
+	UpdateNotebook(userID, notebookID uint, update model.NotebookUpdate) (*model.Notebook, error)
+	DeleteNotebook(userID, notebookID uint, cascade bool) ([]*model.Note, error)
+}
+
+// ShareRepository defines methods for managing the shares of notes with other users.
+type ShareRepository interface {
+	CreateShare(share *model.NoteShare) (*model.NoteShare, error)
+	GetShare(noteID, userID uint) (*model.NoteShare, error)
+	GetSharesOfNote(noteID uint) ([]*model.NoteShare, error)
+	DeleteShare(noteID, shareID uint) error
+	GetNotesSharedWithUser(userID uint) ([]*model.SharedNote, error)
+}
+
+// PublicLinkRepository defines methods for managing the public links of notes.
+type PublicLinkRepository interface {
+	CreateLink(link *model.PublicLink) (*model.PublicLink, error)
+	GetLinksOfNote(noteID uint) ([]*model.PublicLink, error)
+	GetLinkByTokenHash(tokenHash string) (*model.PublicLink, error)
+	DeleteLink(noteID, linkID uint) error
+	RecordView(linkID uint) error
+}
+
 // UserRepository defines methods for user management.
 type UserRepository interface {
 	CreateUser(user *model.User) (*model.User, error)
 	CreateSession(session *model.UserSession) (*model.UserSession, error)
 	GetSessionBySID(sid string) (*model.UserSession, error)
 	GetUserByEmail(email string) (*model.User, error)
-	IsValidSession(sid string) (uint, bool)
+	GetUserByID(userID uint) (*model.User, error)
+	CreateUserToken(token *model.UserToken) (*model.UserToken, error)
+	ResetPassword(tokenHash, passwordHash string) (uint, error)
+	VerifyEmail(tokenHash string) (uint, error)
+	GetUserToken(purpose, tokenHash string) (*model.UserToken, error)
+	UseUserToken(purpose, tokenHash string) (*model.UserToken, error)
+	RecordTokenAttempt(tokenID uint, maxAttempts int) error
+	SetTOTPSecret(userID uint, secret string) error
+	EnableTwoFactor(userID uint, step int64, codeHashes []string) error
+	DisableTwoFactor(userID uint) error
+	UseTOTPStep(userID uint, step int64) error
+	UseRecoveryCode(userID uint, codeHash string) error
+	RecordLockout(lockout *model.LoginLockout) error
+	UpdateUser(userID uint, update model.UserUpdate) (*model.User, error)
+	ChangeEmail(tokenHash string) (uint, error)
+	UpdatePassword(userID uint, passwordHash string) error
+	DeleteUser(userID uint) error
+	RevokeAllSessions(userID uint) error
+	RevokeOtherSessions(userID uint, sid string) error
 	// Add more user-related methods here
 }
+
+// SessionStore defines methods for storing user sessions.
+type SessionStore interface {
+	Create(session *model.UserSession) (*model.UserSession, error)
+	Get(sid string) (*model.UserSession, error)
+	Touch(sid string) error
+	Revoke(sid string) error
+	RevokeAllOfUser(userID uint) error
+	ListByUser(userID uint) ([]*model.UserSession, error)
+}
+
+// LoginThrottle defines methods for tracking the failed logins of a key, such as
+// an email or an IP, and blocking the key for a while.
+type LoginThrottle interface {
+	// Fail records a failed login and returns the number of failures of the key
+	// within the failure window.
+	Fail(key string) (int, error)
+	// Release takes a failure of the key back, for a login counted as failed
+	// before it was tried that turned out not to fail.
+	Release(key string) error
+	// Block refuses the logins of the key for the given duration.
+	Block(key string, d time.Duration) error
+	// BlockedFor returns how long the logins of the key are still refused, 0 if they are not.
+	BlockedFor(key string) (time.Duration, error)
+	// Reset forgets the failures and the block of the key.
+	Reset(key string) error
+}
+
+// EventBus defines methods for delivering note events to the users they concern.
+type EventBus interface {
+	// Publish assigns the ID of the event and delivers it to the given users.
+	Publish(event *model.NoteEvent, userIDs []uint) error
+	// Since retrieves the events of a user that came after the event with the given ID.
+	Since(userID uint, lastID uint64) ([]*model.NoteEvent, error)
+	// Subscribe returns the channel receiving the events of a user from now on, and
+	// a function to stop receiving them. The channel is closed if the subscriber
+	// falls behind or the bus is closed.
+	Subscribe(userID uint) (<-chan *model.NoteEvent, func())
+	Close() error
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetRevisions retrieves the revisions of a note of the user, latest first.
+func (r *noteRepository) GetRevisions(userID, noteID uint) ([]*model.NoteRevision, error) {
+	if _, err := r.GetNoteByID(userID, noteID); err != nil {
+		return nil, err
+	}
+
+	var revisions []*model.NoteRevision
+	if err := r.db.Where("note_id = ?", noteID).Order("revision DESC").Find(&revisions).Error; err != nil {
+		log.Println("[Repo:GetRevisions] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return revisions, nil
+}
+
+// GetRevision retrieves a revision of a note of the user by its number.
+func (r *noteRepository) GetRevision(userID, noteID, revision uint) (*model.NoteRevision, error) {
+	var rev model.NoteRevision
+
+	result := r.db.Joins("JOIN notes ON notes.id = note_revisions.note_id").
+		Where("notes.user_id = ? AND notes.deleted_at IS NULL", userID).
+		Where("note_revisions.note_id = ? AND note_revisions.revision = ?", noteID, revision).
+		First(&rev)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			log.Println("[Repo:GetRevision] ", result.Error)
+			return nil, myerrors.ErrRecordNotFound
+		}
+		log.Println("[Repo:GetRevision] ", result.Error)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return &rev, nil
+}
+
+// RestoreRevision rolls a note of the user back to the title and content of a
+// revision, recording the rollback as a new revision.
+func (r *noteRepository) RestoreRevision(userID, noteID, revision uint) (*model.Note, error) {
+	var note model.Note
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the note so that concurrent updates are applied one after the other
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error; err != nil {
+			return err
+		}
+
+		var rev model.NoteRevision
+		if err := tx.Where("note_id = ? AND revision = ?", noteID, revision).First(&rev).Error; err != nil {
+			return err
+		}
+
+		fields := map[string]interface{}{"title": rev.Title, "content": rev.Content, "version": note.Version + 1}
+		if err := tx.Model(&note).Omit("Tags").Updates(fields).Error; err != nil {
+			return err
+		}
+		if err := addRevision(tx, &note, model.RevisionRestored, &rev.Revision); err != nil {
+			return err
+		}
+
+		return tx.Model(&note).Association("Tags").Find(&note.Tags)
+	})
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:RestoreRevision] ", err)
+			return nil, myerrors.ErrRecordNotFound
+		}
+		log.Println("[Repo:RestoreRevision] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return &note, nil
+}
+
+// addRevision records the current title and content of a note as its next revision.
+// The caller must hold a lock on the note or have just created it.
+func addRevision(tx *gorm.DB, note *model.Note, action string, restoredFrom *uint) error {
+	var last uint
+	if err := tx.Model(&model.NoteRevision{}).Where("note_id = ?", note.ID).
+		Select("COALESCE(MAX(revision), 0)").Scan(&last).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&model.NoteRevision{
+		NoteID:       note.ID,
+		Revision:     last + 1,
+		Title:        note.Title,
+		Content:      note.Content,
+		Action:       action,
+		RestoredFrom: restoredFrom,
+	}).Error
+}
+
+// amendRevision replaces the last revision of a note with its current title and
+// content. A last revision that is not an update is kept and a new one added instead.
+// The caller must hold a lock on the note.
+func amendRevision(tx *gorm.DB, note *model.Note) error {
+	var last model.NoteRevision
+	if err := tx.Where("note_id = ?", note.ID).Order("revision DESC").First(&last).Error; err != nil {
+		return err
+	}
+	if last.Action != model.RevisionUpdated {
+		return addRevision(tx, note, model.RevisionUpdated, nil)
+	}
+
+	return tx.Model(&last).Updates(map[string]interface{}{
+		"title":      note.Title,
+		"content":    note.Content,
+		"created_at": time.Now(),
+	}).Error
+}
+
+// ensureBaseRevision records the state of a note created before revisions were
+// kept, so that its original text is not lost with the first update.
+func ensureBaseRevision(tx *gorm.DB, note *model.Note) error {
+	var count int64
+	if err := tx.Model(&model.NoteRevision{}).Where("note_id = ?", note.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	return addRevision(tx, note, model.RevisionCreated, nil)
+}
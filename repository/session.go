@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"accuknox/model"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// expiredSessionGrace is how long an expired session is kept by a SessionStore
+// so that it can still be reported as expired rather than unknown.
+const expiredSessionGrace = time.Hour
+
+// initSession fills in the ID, SID and timestamps of a session created at now.
+func initSession(session *model.UserSession, id uint, now time.Time, idleTimeout, maxLifetime time.Duration) {
+	// Truncate to seconds as timestamps are stored as unix seconds
+	now = now.Truncate(time.Second)
+
+	session.ID = id
+	session.SID = generateSessionID()
+	session.CreatedAt = now
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(maxLifetime)
+	session.IdleExpiresAt = now.Add(idleTimeout)
+	if session.IdleExpiresAt.After(session.ExpiresAt) {
+		session.IdleExpiresAt = session.ExpiresAt
+	}
+}
+
+func generateSessionID() string {
+	id := uuid.New()
+	return fmt.Sprintf("%v", id.String())
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"sync"
+	"time"
+)
+
+type memorySessionStore struct {
+	mu          sync.Mutex
+	sessions    map[string]*model.UserSession
+	byUser      map[uint]map[string]struct{}
+	lastID      uint
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+	now         func() time.Time
+}
+
+// NewMemorySessionStore creates a new SessionStore keeping sessions in process memory.
+// It is meant for local development and tests, sessions do not survive a restart.
+func NewMemorySessionStore(idleTimeout, maxLifetime time.Duration) SessionStore {
+	return &memorySessionStore{
+		sessions:    make(map[string]*model.UserSession),
+		byUser:      make(map[uint]map[string]struct{}),
+		idleTimeout: idleTimeout,
+		maxLifetime: maxLifetime,
+		now:         time.Now,
+	}
+}
+
+// Create stores a new session for session.UserID and assigns its ID and SID.
+func (s *memorySessionStore) Create(session *model.UserSession) (*model.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired sessions so that the store does not grow forever
+	s.purgeExpired()
+
+	s.lastID++
+	initSession(session, s.lastID, s.now(), s.idleTimeout, s.maxLifetime)
+
+	stored := *session
+	s.sessions[session.SID] = &stored
+	if s.byUser[session.UserID] == nil {
+		s.byUser[session.UserID] = make(map[string]struct{})
+	}
+	s.byUser[session.UserID][session.SID] = struct{}{}
+
+	return session, nil
+}
+
+// Get retrieves a session by its SID.
+func (s *memorySessionStore) Get(sid string) (*model.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.lookup(sid)
+	if !ok {
+		return nil, myerrors.ErrRecordNotFound
+	}
+
+	found := *session
+	return &found, nil
+}
+
+// Touch records that the session was just used and extends its idle expiry.
+func (s *memorySessionStore) Touch(sid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.lookup(sid)
+	if !ok {
+		return nil
+	}
+
+	now := s.now()
+	session.LastSeenAt = now.Truncate(time.Second)
+	session.IdleExpiresAt = now.Add(s.idleTimeout).Truncate(time.Second)
+	if session.IdleExpiresAt.After(session.ExpiresAt) {
+		session.IdleExpiresAt = session.ExpiresAt
+	}
+
+	return nil
+}
+
+// Revoke deletes the session with the given SID.
+func (s *memorySessionStore) Revoke(sid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup(sid); !ok {
+		return myerrors.ErrRecordNotFound
+	}
+	s.remove(sid)
+
+	return nil
+}
+
+// RevokeAllOfUser deletes every session of the given user.
+func (s *memorySessionStore) RevokeAllOfUser(userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sid := range s.byUser[userID] {
+		s.remove(sid)
+	}
+
+	return nil
+}
+
+// ListByUser retrieves all active sessions of a user.
+func (s *memorySessionStore) ListByUser(userID uint) ([]*model.UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	sessions := make([]*model.UserSession, 0, len(s.byUser[userID]))
+	for sid := range s.byUser[userID] {
+		session, ok := s.lookup(sid)
+		if !ok || now.After(session.IdleExpiresAt) {
+			continue
+		}
+
+		found := *session
+		sessions = append(sessions, &found)
+	}
+
+	return sessions, nil
+}
+
+// lookup returns the stored session, removing it if it is past its grace period.
+// The caller must hold s.mu.
+func (s *memorySessionStore) lookup(sid string) (*model.UserSession, bool) {
+	session, ok := s.sessions[sid]
+	if !ok {
+		return nil, false
+	}
+
+	if s.now().After(session.IdleExpiresAt.Add(expiredSessionGrace)) {
+		s.remove(sid)
+		return nil, false
+	}
+
+	return session, true
+}
+
+// remove deletes the session and its index entry. The caller must hold s.mu.
+func (s *memorySessionStore) remove(sid string) {
+	session, ok := s.sessions[sid]
+	if !ok {
+		return
+	}
+
+	delete(s.sessions, sid)
+	delete(s.byUser[session.UserID], sid)
+	if len(s.byUser[session.UserID]) == 0 {
+		delete(s.byUser, session.UserID)
+	}
+}
+
+// purgeExpired removes every session past its grace period. The caller must hold s.mu.
+func (s *memorySessionStore) purgeExpired() {
+	for sid := range s.sessions {
+		s.lookup(sid)
+	}
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"testing"
+	"time"
+)
+
+func newTestMemorySessionStore(now *time.Time) *memorySessionStore {
+	store := NewMemorySessionStore(time.Hour, 4*time.Hour).(*memorySessionStore)
+	store.now = func() time.Time { return *now }
+	return store
+}
+
+func TestMemorySessionStoreLifecycle(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := newTestMemorySessionStore(&now)
+
+	session, err := store.Create(&model.UserSession{UserID: 7, IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.SID == "" || session.ID == 0 {
+		t.Fatalf("session not initialized: %+v", session)
+	}
+	if want := now.Add(time.Hour); !session.IdleExpiresAt.Equal(want) {
+		t.Errorf("IdleExpiresAt = %v, want %v", session.IdleExpiresAt, want)
+	}
+
+	// Touching slides the idle expiry
+	now = now.Add(30 * time.Minute)
+	if err := store.Touch(session.SID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(session.SID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Add(time.Hour); !got.IdleExpiresAt.Equal(want) {
+		t.Errorf("IdleExpiresAt after touch = %v, want %v", got.IdleExpiresAt, want)
+	}
+
+	// The idle expiry never goes past the absolute expiry
+	for i := 0; i < 7; i++ {
+		now = now.Add(30 * time.Minute)
+		store.Touch(session.SID)
+	}
+	got, err = store.Get(session.SID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IdleExpiresAt.Equal(got.ExpiresAt) {
+		t.Errorf("IdleExpiresAt = %v, want capped at %v", got.IdleExpiresAt, got.ExpiresAt)
+	}
+
+	if err := store.Revoke(session.SID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(session.SID); err != myerrors.ErrRecordNotFound {
+		t.Errorf("Get after revoke: err = %v, want %v", err, myerrors.ErrRecordNotFound)
+	}
+}
+
+func TestMemorySessionStoreExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := newTestMemorySessionStore(&now)
+
+	session, _ := store.Create(&model.UserSession{UserID: 7})
+
+	// Idle sessions are no longer listed but can still be fetched during the grace period
+	now = now.Add(time.Hour + time.Minute)
+	if sessions, _ := store.ListByUser(7); len(sessions) != 0 {
+		t.Errorf("ListByUser = %d sessions, want 0", len(sessions))
+	}
+	if _, err := store.Get(session.SID); err != nil {
+		t.Errorf("Get during grace period: %v", err)
+	}
+
+	// After the grace period the session is gone
+	now = now.Add(expiredSessionGrace)
+	if _, err := store.Get(session.SID); err != myerrors.ErrRecordNotFound {
+		t.Errorf("Get after grace period: err = %v, want %v", err, myerrors.ErrRecordNotFound)
+	}
+}
+
+func TestMemorySessionStoreRevokeAllOfUser(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := newTestMemorySessionStore(&now)
+
+	store.Create(&model.UserSession{UserID: 1})
+	store.Create(&model.UserSession{UserID: 1})
+	other, _ := store.Create(&model.UserSession{UserID: 2})
+
+	if sessions, _ := store.ListByUser(1); len(sessions) != 2 {
+		t.Fatalf("ListByUser = %d sessions, want 2", len(sessions))
+	}
+
+	if err := store.RevokeAllOfUser(1); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ := store.ListByUser(1); len(sessions) != 0 {
+		t.Errorf("ListByUser after revoke = %d sessions, want 0", len(sessions))
+	}
+	if _, err := store.Get(other.SID); err != nil {
+		t.Errorf("session of another user was revoked: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// touchSessionScript slides the idle expiry of a session, without going past its
+// absolute expiry, and refreshes the TTL of its key accordingly. It does nothing
+// if the session no longer exists so that it is never recreated without a TTL.
+var touchSessionScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local idleExpiresAt = math.min(tonumber(ARGV[2]), tonumber(redis.call("HGET", KEYS[1], "expires_at")))
+redis.call("HSET", KEYS[1], "last_seen_at", ARGV[1], "idle_expires_at", idleExpiresAt)
+redis.call("EXPIREAT", KEYS[1], idleExpiresAt + tonumber(ARGV[3]))
+return 1
+`)
+
+// sessionSeqKey is the redis key of the counter used to number sessions.
+const sessionSeqKey = "session_seq"
+
+type redisSessionStore struct {
+	rClient     *redis.Client
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+}
+
+// NewRedisSessionStore creates a new SessionStore keeping sessions in redis.
+// Sessions expire after idleTimeout without use and at the latest maxLifetime after creation.
+func NewRedisSessionStore(rClient *redis.Client, idleTimeout, maxLifetime time.Duration) SessionStore {
+	return &redisSessionStore{rClient, idleTimeout, maxLifetime}
+}
+
+// Create stores a new session for session.UserID and assigns its ID and SID.
+func (s *redisSessionStore) Create(session *model.UserSession) (*model.UserSession, error) {
+	// Sessions get a numeric ID so they can be referenced without exposing the SID
+	id, err := s.rClient.Incr(sessionSeqKey).Result()
+	if err != nil {
+		log.Println("[SessionStore:Create] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	initSession(session, uint(id), time.Now(), s.idleTimeout, s.maxLifetime)
+
+	// Store the session and index it under its user so that all sessions
+	// of a user can be found and revoked together
+	pipe := s.rClient.TxPipeline()
+	pipe.HMSet(sessionKey(session.SID), sessionToHash(session))
+	pipe.ExpireAt(sessionKey(session.SID), session.IdleExpiresAt.Add(expiredSessionGrace))
+	pipe.SAdd(userSessionsKey(session.UserID), session.SID)
+	pipe.Expire(userSessionsKey(session.UserID), s.maxLifetime+expiredSessionGrace)
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("[SessionStore:Create] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return session, nil
+}
+
+// Get retrieves a session by its SID.
+func (s *redisSessionStore) Get(sid string) (*model.UserSession, error) {
+	fields, err := s.rClient.HGetAll(sessionKey(sid)).Result()
+	if err != nil {
+		log.Println("[SessionStore:Get] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	// HGETALL returns an empty map for missing or expired keys
+	if len(fields) == 0 {
+		return nil, myerrors.ErrRecordNotFound
+	}
+
+	return sessionFromHash(sid, fields)
+}
+
+// Touch records that the session was just used and extends its idle expiry.
+func (s *redisSessionStore) Touch(sid string) error {
+	now := time.Now()
+	err := touchSessionScript.Run(s.rClient, []string{sessionKey(sid)},
+		now.Unix(), now.Add(s.idleTimeout).Unix(), int64(expiredSessionGrace.Seconds())).Err()
+	if err != nil && err != redis.Nil {
+		log.Println("[SessionStore:Touch] ", err)
+		return myerrors.ErrInternalServer
+	}
+
+	return nil
+}
+
+// Revoke deletes the session with the given SID.
+func (s *redisSessionStore) Revoke(sid string) error {
+	session, err := s.Get(sid)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.rClient.TxPipeline()
+	pipe.Del(sessionKey(sid))
+	pipe.SRem(userSessionsKey(session.UserID), sid)
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("[SessionStore:Revoke] ", err)
+		return myerrors.ErrInternalServer
+	}
+
+	return nil
+}
+
+// RevokeAllOfUser deletes every session of the given user.
+func (s *redisSessionStore) RevokeAllOfUser(userID uint) error {
+	key := userSessionsKey(userID)
+	sids, err := s.rClient.SMembers(key).Result()
+	if err != nil {
+		log.Println("[SessionStore:RevokeAllOfUser] ", err)
+		return myerrors.ErrInternalServer
+	}
+
+	pipe := s.rClient.TxPipeline()
+	for _, sid := range sids {
+		pipe.Del(sessionKey(sid))
+	}
+	pipe.Del(key)
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("[SessionStore:RevokeAllOfUser] ", err)
+		return myerrors.ErrInternalServer
+	}
+
+	return nil
+}
+
+// ListByUser retrieves all active sessions of a user.
+func (s *redisSessionStore) ListByUser(userID uint) ([]*model.UserSession, error) {
+	key := userSessionsKey(userID)
+	sids, err := s.rClient.SMembers(key).Result()
+	if err != nil {
+		log.Println("[SessionStore:ListByUser] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	// Fetch all sessions in a single round trip
+	pipe := s.rClient.Pipeline()
+	cmds := make([]*redis.StringStringMapCmd, len(sids))
+	for i, sid := range sids {
+		cmds[i] = pipe.HGetAll(sessionKey(sid))
+	}
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("[SessionStore:ListByUser] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	now := time.Now()
+	sessions := make([]*model.UserSession, 0, len(sids))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			// The session is gone, drop it from the index
+			s.rClient.SRem(key, sids[i])
+			continue
+		}
+
+		session, err := sessionFromHash(sids[i], fields)
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip sessions that expired but are still kept for the grace period
+		if now.After(session.IdleExpiresAt) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// sessionKey returns the redis key of the hash holding a session.
+func sessionKey(sid string) string {
+	return "session:" + sid
+}
+
+// userSessionsKey returns the redis key of the set holding all SIDs of a user.
+func userSessionsKey(userID uint) string {
+	return fmt.Sprintf("user_sessions:%d", userID)
+}
+
+// sessionToHash converts a session into the fields of its redis hash.
+func sessionToHash(session *model.UserSession) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              session.ID,
+		"user_id":         session.UserID,
+		"ip":              session.IP,
+		"user_agent":      session.UserAgent,
+		"created_at":      session.CreatedAt.Unix(),
+		"last_seen_at":    session.LastSeenAt.Unix(),
+		"expires_at":      session.ExpiresAt.Unix(),
+		"idle_expires_at": session.IdleExpiresAt.Unix(),
+	}
+}
+
+// sessionFromHash builds a session from the fields of its redis hash.
+func sessionFromHash(sid string, fields map[string]string) (*model.UserSession, error) {
+	var nums [6]int64
+	for i, name := range []string{"id", "user_id", "created_at", "last_seen_at", "expires_at", "idle_expires_at"} {
+		n, err := strconv.ParseInt(fields[name], 10, 64)
+		if err != nil {
+			log.Println("[SessionStore:sessionFromHash] ", err)
+			return nil, myerrors.ErrInternalServer
+		}
+		nums[i] = n
+	}
+
+	return &model.UserSession{
+		ID:            uint(nums[0]),
+		UserID:        uint(nums[1]),
+		SID:           sid,
+		IP:            fields["ip"],
+		UserAgent:     fields["user_agent"],
+		CreatedAt:     time.Unix(nums[2], 0),
+		LastSeenAt:    time.Unix(nums[3], 0),
+		ExpiresAt:     time.Unix(nums[4], 0),
+		IdleExpiresAt: time.Unix(nums[5], 0),
+	}, nil
+}
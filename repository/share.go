@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"log"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type shareRepository struct {
+	db *gorm.DB
+}
+
+// NewShareRepository creates a new ShareRepository with the given database connection.
+func NewShareRepository(db *gorm.DB) ShareRepository {
+	return &shareRepository{db}
+}
+
+// CreateShare shares a note with a user. If the note is already shared with
+// the user, the permission of the existing share is replaced.
+func (r *shareRepository) CreateShare(share *model.NoteShare) (*model.NoteShare, error) {
+	result := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "note_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"permission", "updated_at"}),
+	}).Omit("User").Create(share)
+
+	if result.Error != nil {
+		log.Println("[Repo:CreateShare] ", result.Error)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return share, nil
+}
+
+// GetShare retrieves the share of a note with a user.
+func (r *shareRepository) GetShare(noteID, userID uint) (*model.NoteShare, error) {
+	var share model.NoteShare
+	if err := r.db.Where("note_id = ? AND user_id = ?", noteID, userID).First(&share).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, myerrors.ErrRecordNotFound
+		}
+
+		log.Println("[Repo:GetShare] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+	return &share, nil
+}
+
+// GetSharesOfNote retrieves the shares of a note with the users they were granted to.
+func (r *shareRepository) GetSharesOfNote(noteID uint) ([]*model.NoteShare, error) {
+	var shares []*model.NoteShare
+
+	result := r.db.Preload("User").Where("note_id = ?", noteID).Order("created_at, id").Find(&shares)
+	if result.Error != nil {
+		log.Println("[Repo:GetSharesOfNote] ", result.Error)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return shares, nil
+}
+
+// DeleteShare revokes a share of a note.
+func (r *shareRepository) DeleteShare(noteID, shareID uint) error {
+	result := r.db.Where("id = ? AND note_id = ?", shareID, noteID).Delete(&model.NoteShare{})
+
+	if result.Error != nil {
+		log.Println("[Repo:DeleteShare] ", result.Error)
+		return myerrors.ErrInternalServer
+	}
+	if result.RowsAffected == 0 {
+		return myerrors.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetNotesSharedWithUser retrieves the notes other users shared with the user,
+// most recently shared first. Notes in the trash of their owner are left out.
+func (r *shareRepository) GetNotesSharedWithUser(userID uint) ([]*model.SharedNote, error) {
+	var notes []*model.SharedNote
+
+	result := r.db.Model(&model.Note{}).
+		Select("notes.*, note_shares.permission, users.name AS owner_name, users.email AS owner_email").
+		Joins("JOIN note_shares ON note_shares.note_id = notes.id").
+		Joins("JOIN users ON users.id = notes.user_id").
+		Where("note_shares.user_id = ?", userID).
+		Order("note_shares.created_at DESC, notes.id DESC").
+		Scan(&notes)
+
+	if result.Error != nil {
+		log.Println("[Repo:GetNotesSharedWithUser] ", result.Error)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return notes, nil
+}
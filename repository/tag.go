@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"log"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type tagRepository struct {
+	db *gorm.DB
+}
+
+// NewTagRepository creates a new TagRepository with the given database connection.
+func NewTagRepository(db *gorm.DB) TagRepository {
+	return &tagRepository{db}
+}
+
+// GetTagsOfUser retrieves all tags of a user with the number of notes using them,
+// notes in the trash are not counted.
+func (r *tagRepository) GetTagsOfUser(userID uint) ([]*model.TagUsage, error) {
+	var tags []*model.TagUsage
+
+	result := r.db.Model(&model.Tag{}).
+		Select("tags.id, tags.user_id, tags.name, COUNT(notes.id) AS note_count").
+		Joins("LEFT JOIN note_tags ON note_tags.tag_id = tags.id").
+		Joins("LEFT JOIN notes ON notes.id = note_tags.note_id AND notes.deleted_at IS NULL").
+		Where("tags.user_id = ?", userID).
+		Group("tags.id").
+		Order("tags.name").
+		Scan(&tags)
+
+	if result.Error != nil {
+		log.Println("[Repo:GetTagsOfUser] ", result.Error)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return tags, nil
+}
+
+// RenameTag renames a tag of the user on all of their notes and returns the notes
+// using it. It returns myerrors.ErrConflict if the user already has a tag with the new name.
+func (r *tagRepository) RenameTag(userID, tagID uint, name string) (*model.Tag, []*model.Note, error) {
+	var tag model.Tag
+	var notes []*model.Note
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", tagID, userID).First(&tag).Error; err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&model.Tag{}).Where("user_id = ? AND name = ? AND id <> ?", userID, name, tagID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return myerrors.ErrConflict
+		}
+
+		if err := tx.Model(&tag).Update("name", name).Error; err != nil {
+			return err
+		}
+
+		noteIDs, err := notesWithTag(tx, tagID)
+		if err != nil {
+			return err
+		}
+		notes, err = updateNotes(tx, noteIDs, map[string]interface{}{})
+		return err
+	})
+
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			log.Println("[Repo:RenameTag] ", err)
+			return nil, nil, myerrors.ErrRecordNotFound
+		case myerrors.ErrConflict:
+			return nil, nil, err
+		default:
+			log.Println("[Repo:RenameTag] ", err)
+			return nil, nil, myerrors.ErrInternalServer
+		}
+	}
+
+	return &tag, notes, nil
+}
+
+// DeleteTag deletes a tag of the user, removes it from all of their notes and
+// returns these notes.
+func (r *tagRepository) DeleteTag(userID, tagID uint) ([]*model.Note, error) {
+	var notes []*model.Note
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Look the notes up first as the note_tags rows are removed by the
+		// ON DELETE CASCADE constraint
+		noteIDs, err := notesWithTag(tx, tagID)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Where("id = ? AND user_id = ?", tagID, userID).Delete(&model.Tag{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return myerrors.ErrRecordNotFound
+		}
+
+		notes, err = updateNotes(tx, noteIDs, map[string]interface{}{})
+		return err
+	})
+
+	if err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			return nil, err
+		}
+		log.Println("[Repo:DeleteTag] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return notes, nil
+}
+
+// notesWithTag returns the IDs of the notes using a tag, except the ones in the trash.
+func notesWithTag(tx *gorm.DB, tagID uint) ([]uint, error) {
+	var noteIDs []uint
+	err := tx.Model(&model.Note{}).
+		Joins("JOIN note_tags ON note_tags.note_id = notes.id").
+		Where("note_tags.tag_id = ?", tagID).
+		Pluck("notes.id", &noteIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return noteIDs, nil
+}
+
+// resolveTags returns the tags of the user with the given names, creating the missing ones.
+func resolveTags(tx *gorm.DB, userID uint, names []string) ([]model.Tag, error) {
+	tags := make([]model.Tag, 0, len(names))
+	if len(names) == 0 {
+		return tags, nil
+	}
+
+	for _, name := range names {
+		tags = append(tags, model.Tag{UserID: userID, Name: name})
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tags).Error; err != nil {
+		return nil, err
+	}
+
+	// Reload them as the IDs of the tags that already existed are not returned
+	tags = tags[:0]
+	if err := tx.Where("user_id = ? AND name IN ?", userID, names).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
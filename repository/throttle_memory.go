@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+// loginFailures counts the failed logins of a key until expiresAt.
+type loginFailures struct {
+	count     int
+	expiresAt time.Time
+}
+
+type memoryLoginThrottle struct {
+	mu        sync.Mutex
+	failures  map[string]*loginFailures
+	blocks    map[string]time.Time
+	window    time.Duration
+	nextPurge time.Time
+	now       func() time.Time
+}
+
+// NewMemoryLoginThrottle creates a new LoginThrottle keeping the failed logins in
+// process memory for window after the last failure. It is meant for local
+// development and tests, each instance counts the failures on its own.
+func NewMemoryLoginThrottle(window time.Duration) LoginThrottle {
+	return &memoryLoginThrottle{
+		failures: make(map[string]*loginFailures),
+		blocks:   make(map[string]time.Time),
+		window:   window,
+		now:      time.Now,
+	}
+}
+
+// Fail records a failed login of the key.
+func (t *memoryLoginThrottle) Fail(key string) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.purgeExpired(now)
+
+	failures := t.failures[key]
+	if failures == nil || !now.Before(failures.expiresAt) {
+		failures = &loginFailures{}
+		t.failures[key] = failures
+	}
+	failures.count++
+	failures.expiresAt = now.Add(t.window)
+
+	return failures.count, nil
+}
+
+// Release takes a failure of the key back.
+func (t *memoryLoginThrottle) Release(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if failures := t.failures[key]; failures != nil && failures.count > 0 {
+		failures.count--
+	}
+	return nil
+}
+
+// Block refuses the logins of the key for d.
+func (t *memoryLoginThrottle) Block(key string, d time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.blocks[key] = t.now().Add(d)
+	return nil
+}
+
+// BlockedFor returns how long the logins of the key are still refused.
+func (t *memoryLoginThrottle) BlockedFor(key string) (time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	until, ok := t.blocks[key]
+	if !ok {
+		return 0, nil
+	}
+	if left := until.Sub(t.now()); left > 0 {
+		return left, nil
+	}
+	delete(t.blocks, key)
+	return 0, nil
+}
+
+// Reset forgets the failures and the block of the key.
+func (t *memoryLoginThrottle) Reset(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.failures, key)
+	delete(t.blocks, key)
+	return nil
+}
+
+// purgeExpired drops the expired failures and blocks at most once per window,
+// so that the maps do not grow with every email and IP ever seen.
+func (t *memoryLoginThrottle) purgeExpired(now time.Time) {
+	if now.Before(t.nextPurge) {
+		return
+	}
+	t.nextPurge = now.Add(t.window)
+
+	for key, failures := range t.failures {
+		if !now.Before(failures.expiresAt) {
+			delete(t.failures, key)
+		}
+	}
+	for key, until := range t.blocks {
+		if !now.Before(until) {
+			delete(t.blocks, key)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryLoginThrottle(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	throttle := NewMemoryLoginThrottle(time.Hour).(*memoryLoginThrottle)
+	throttle.now = func() time.Time { return now }
+
+	for want := 1; want <= 3; want++ {
+		if got, _ := throttle.Fail("email:ana@example.com"); got != want {
+			t.Errorf("Fail = %d, want %d", got, want)
+		}
+	}
+
+	// Failures are forgotten a window after the last one
+	now = now.Add(time.Hour)
+	if got, _ := throttle.Fail("email:ana@example.com"); got != 1 {
+		t.Errorf("Fail after the window = %d, want 1", got)
+	}
+
+	throttle.Fail("email:ana@example.com")
+	throttle.Release("email:ana@example.com")
+	if got, _ := throttle.Fail("email:ana@example.com"); got != 2 {
+		t.Errorf("Fail after release = %d, want 2", got)
+	}
+
+	throttle.Block("email:ana@example.com", 15*time.Minute)
+	now = now.Add(5 * time.Minute)
+	if left, _ := throttle.BlockedFor("email:ana@example.com"); left != 10*time.Minute {
+		t.Errorf("BlockedFor = %v, want 10m", left)
+	}
+	if left, _ := throttle.BlockedFor("ip:10.0.0.1"); left != 0 {
+		t.Errorf("BlockedFor of another key = %v, want 0", left)
+	}
+
+	throttle.Reset("email:ana@example.com")
+	if left, _ := throttle.BlockedFor("email:ana@example.com"); left != 0 {
+		t.Errorf("BlockedFor after reset = %v, want 0", left)
+	}
+	if got, _ := throttle.Fail("email:ana@example.com"); got != 1 {
+		t.Errorf("Fail after reset = %d, want 1", got)
+	}
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"accuknox/myerrors"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// releaseFailureScript takes a failure of a key back. It does nothing if the
+// failures expired so that the counter is never recreated without a TTL.
+var releaseFailureScript = redis.NewScript(`
+local count = tonumber(redis.call("GET", KEYS[1]))
+if not count or count <= 0 then
+	return 0
+end
+return redis.call("DECR", KEYS[1])
+`)
+
+type redisLoginThrottle struct {
+	rClient *redis.Client
+	window  time.Duration
+}
+
+// NewRedisLoginThrottle creates a new LoginThrottle keeping the failed logins in
+// redis for window after the last failure, so that all instances share them.
+func NewRedisLoginThrottle(rClient *redis.Client, window time.Duration) LoginThrottle {
+	return &redisLoginThrottle{rClient, window}
+}
+
+// Fail records a failed login of the key.
+func (t *redisLoginThrottle) Fail(key string) (int, error) {
+	pipe := t.rClient.TxPipeline()
+	count := pipe.Incr(loginFailuresKey(key))
+	pipe.Expire(loginFailuresKey(key), t.window)
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("[LoginThrottle:Fail] ", err)
+		return 0, myerrors.ErrInternalServer
+	}
+	return int(count.Val()), nil
+}
+
+// Release takes a failure of the key back.
+func (t *redisLoginThrottle) Release(key string) error {
+	err := releaseFailureScript.Run(t.rClient, []string{loginFailuresKey(key)}).Err()
+	if err != nil && err != redis.Nil {
+		log.Println("[LoginThrottle:Release] ", err)
+		return myerrors.ErrInternalServer
+	}
+	return nil
+}
+
+// Block refuses the logins of the key for d.
+func (t *redisLoginThrottle) Block(key string, d time.Duration) error {
+	if err := t.rClient.Set(loginBlockKey(key), 1, d).Err(); err != nil {
+		log.Println("[LoginThrottle:Block] ", err)
+		return myerrors.ErrInternalServer
+	}
+	return nil
+}
+
+// BlockedFor returns how long the logins of the key are still refused.
+func (t *redisLoginThrottle) BlockedFor(key string) (time.Duration, error) {
+	ttl, err := t.rClient.PTTL(loginBlockKey(key)).Result()
+	if err != nil {
+		log.Println("[LoginThrottle:BlockedFor] ", err)
+		return 0, myerrors.ErrInternalServer
+	}
+	// Missing keys have a negative TTL
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Reset forgets the failures and the block of the key.
+func (t *redisLoginThrottle) Reset(key string) error {
+	if err := t.rClient.Del(loginFailuresKey(key), loginBlockKey(key)).Err(); err != nil {
+		log.Println("[LoginThrottle:Reset] ", err)
+		return myerrors.ErrInternalServer
+	}
+	return nil
+}
+
+// loginFailuresKey returns the redis key counting the failed logins of a key.
+func loginFailuresKey(key string) string {
+	return "login_failures:" + key
+}
+
+// loginBlockKey returns the redis key set while the logins of a key are refused.
+func loginBlockKey(key string) string {
+	return "login_block:" + key
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SetTOTPSecret stores the encrypted secret of a two-factor setup that is not
+// confirmed yet. It returns myerrors.ErrConflict if two-factor authentication is
+// already enabled for the user.
+func (r *userRepository) SetTOTPSecret(userID uint, secret string) error {
+	result := r.db.Model(&model.User{}).
+		Where("id = ? AND two_factor_enabled = ?", userID, false).
+		Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0})
+
+	if result.Error != nil {
+		log.Println("[Repo:SetTOTPSecret] ", result.Error)
+		return myerrors.ErrInternalServer
+	}
+	if result.RowsAffected == 0 {
+		return myerrors.ErrConflict
+	}
+	return nil
+}
+
+// EnableTwoFactor enables two-factor authentication for the user with the secret
+// that was set up, recording the time step of the code that confirmed it and
+// replacing the recovery codes of the user. It returns myerrors.ErrConflict if it
+// is already enabled or was not set up.
+func (r *userRepository) EnableTwoFactor(userID uint, step int64, codeHashes []string) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.User{}).
+			Where("id = ? AND two_factor_enabled = ? AND totp_secret <> ''", userID, false).
+			Updates(map[string]interface{}{"two_factor_enabled": true, "totp_last_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return myerrors.ErrConflict
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]model.RecoveryCode, 0, len(codeHashes))
+		for _, hash := range codeHashes {
+			codes = append(codes, model.RecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		return tx.Omit("User").Create(&codes).Error
+	})
+
+	if err != nil {
+		if err == myerrors.ErrConflict {
+			return err
+		}
+		log.Println("[Repo:EnableTwoFactor] ", err)
+		return myerrors.ErrInternalServer
+	}
+	return nil
+}
+
+// DisableTwoFactor disables two-factor authentication for the user, dropping
+// their secret and recovery codes.
+func (r *userRepository) DisableTwoFactor(userID uint) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"two_factor_enabled": false,
+			"totp_secret":        "",
+			"totp_last_step":     0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error
+	})
+
+	if err != nil {
+		log.Println("[Repo:DisableTwoFactor] ", err)
+		return myerrors.ErrInternalServer
+	}
+	return nil
+}
+
+// UseTOTPStep records that the code of a time step was used, so that it cannot
+// be used again. It returns myerrors.ErrConflict if a code of this step or a later
+// one was already used.
+func (r *userRepository) UseTOTPStep(userID uint, step int64) error {
+	result := r.db.Model(&model.User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+
+	if result.Error != nil {
+		log.Println("[Repo:UseTOTPStep] ", result.Error)
+		return myerrors.ErrInternalServer
+	}
+	if result.RowsAffected == 0 {
+		return myerrors.ErrConflict
+	}
+	return nil
+}
+
+// UseRecoveryCode uses up an unused recovery code of the user. It returns
+// myerrors.ErrRecordNotFound if the user has no such code.
+func (r *userRepository) UseRecoveryCode(userID uint, codeHash string) error {
+	result := r.db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+
+	if result.Error != nil {
+		log.Println("[Repo:UseRecoveryCode] ", result.Error)
+		return myerrors.ErrInternalServer
+	}
+	if result.RowsAffected == 0 {
+		return myerrors.ErrRecordNotFound
+	}
+	return nil
+}
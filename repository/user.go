@@ -3,24 +3,46 @@ package repository
 import (
 	"accuknox/model"
 	"accuknox/myerrors"
-	"fmt"
 	"log"
-	"strconv"
 	"time"
 
-	"github.com/go-redis/redis"
-	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// emailVerifiedMigrations add the email_verified column of users before AutoMigrate
+// does, with the accounts created before emails were verified counting as verified.
+var emailVerifiedMigrations = []string{
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified boolean NOT NULL DEFAULT true`,
+	`ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT false`,
+}
+
+// MigrateEmailVerified adds the email_verified column of existing users. It must
+// run before the users table is auto migrated, new databases need nothing.
+func MigrateEmailVerified(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&model.User{}) {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range emailVerifiedMigrations {
+			if err := tx.Exec(stmt).Error; err != nil {
+				log.Println("[Repo:MigrateEmailVerified] ", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 type userRepository struct {
-	db      *gorm.DB
-	rClient *redis.Client
+	db       *gorm.DB
+	sessions SessionStore
 }
 
-// NewUserRepository creates a new UserRepository with the given database connection.
-func NewUserRepository(db *gorm.DB, rClient *redis.Client) UserRepository {
-	return &userRepository{db, rClient}
+// NewUserRepository creates a new UserRepository with the given database connection
+// and session store.
+func NewUserRepository(db *gorm.DB, sessions SessionStore) UserRepository {
+	return &userRepository{db, sessions}
 }
 
 // CreateUser creates a new user.
@@ -40,25 +62,12 @@ func (r *userRepository) CreateUser(user *model.User) (*model.User, error) {
 
 // CreateSession creates a new user session.
 func (r *userRepository) CreateSession(session *model.UserSession) (*model.UserSession, error) {
-	// Use GORM's Create method to insert the session into the database
-	sid, err := generateSessionID()
-	if err != nil {
-		log.Println("[Repo:GetUserByEmail] ", err)
-		return nil, myerrors.ErrInternalServer
-	}
-	session.SID = sid
-
-	exp := time.Duration(24 * time.Hour) // 24 Hours
-	r.rClient.Set(sid, session.UserID, exp).Err()
-
-	// Return the created session
-	return session, nil
+	return r.sessions.Create(session)
 }
 
 // GetSessionBySID retrieves a user session by its SID.
 func (r *userRepository) GetSessionBySID(sid string) (*model.UserSession, error) {
-	// Implement the GetSessionBySID method
-	return nil, nil
+	return r.sessions.Get(sid)
 }
 
 // GetUserByEmail retrieves a user by their email.
@@ -75,24 +84,373 @@ func (r *userRepository) GetUserByEmail(email string) (*model.User, error) {
 	return &user, nil
 }
 
-// IsValidSession checks if the session ID (SID) is valid and returns the userID if valid.
-func (r *userRepository) IsValidSession(sid string) (uint, bool) {
-	val, err := r.rClient.Get(sid).Result()
+// GetUserByID retrieves a user by their ID.
+func (r *userRepository) GetUserByID(userID uint) (*model.User, error) {
+	var user model.User
+	if err := r.db.First(&user, userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:GetUserByID] ", err)
+			return nil, myerrors.ErrRecordNotFound // User not found
+		}
+
+		return nil, err // Database error
+	}
+	return &user, nil
+}
+
+// CreateUserToken stores a token sent to a user, replacing the tokens of the same
+// purpose that were sent to them before so that only the latest one works.
+func (r *userRepository) CreateUserToken(token *model.UserToken) (*model.UserToken, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND purpose = ?", token.UserID, token.Purpose).Delete(&model.UserToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Omit("User").Create(token).Error
+	})
+
 	if err != nil {
-		return 0, false
+		log.Println("[Repo:CreateUserToken] ", err)
+		return nil, myerrors.ErrInternalServer
 	}
 
-	userid, err := strconv.Atoi(val)
+	return token, nil
+}
+
+// ResetPassword sets the password hash of the user a password reset token was
+// sent to and uses the token up. It returns the ID of the user,
+// myerrors.ErrRecordNotFound if the token is unknown or was used and
+// myerrors.ErrExpired if it expired.
+func (r *userRepository) ResetPassword(tokenHash, passwordHash string) (uint, error) {
+	var userID uint
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		token, err := useUserToken(tx, model.TokenPasswordReset, tokenHash)
+		if err != nil {
+			return err
+		}
+		userID = token.UserID
+
+		return tx.Model(&model.User{}).Where("id = ?", token.UserID).Update("password_hash", passwordHash).Error
+	})
+
 	if err != nil {
-		return 0, false
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:ResetPassword] ", err)
+			return 0, myerrors.ErrRecordNotFound
+		}
+		if err == myerrors.ErrExpired {
+			return 0, err
+		}
+		log.Println("[Repo:ResetPassword] ", err)
+		return 0, myerrors.ErrInternalServer
 	}
 
-	return uint(userid), true
+	return userID, nil
 }
 
-// Rest of the UserRepository methods...
+// VerifyEmail marks the email of the user an email verification token was sent
+// to as verified and uses the token up. It returns the ID of the user,
+// myerrors.ErrRecordNotFound if the token is unknown or was used and
+// myerrors.ErrExpired if it expired.
+func (r *userRepository) VerifyEmail(tokenHash string) (uint, error) {
+	var userID uint
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		token, err := useUserToken(tx, model.TokenEmailVerification, tokenHash)
+		if err != nil {
+			return err
+		}
+		userID = token.UserID
 
-func generateSessionID() (string, error) {
-	id := uuid.New()
-	return fmt.Sprintf("%v", id.String()), nil
+		return tx.Model(&model.User{}).Where("id = ?", token.UserID).Update("email_verified", true).Error
+	})
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:VerifyEmail] ", err)
+			return 0, myerrors.ErrRecordNotFound
+		}
+		if err == myerrors.ErrExpired {
+			return 0, err
+		}
+		log.Println("[Repo:VerifyEmail] ", err)
+		return 0, myerrors.ErrInternalServer
+	}
+
+	return userID, nil
+}
+
+// GetUserToken retrieves an unused token of the given purpose by its hash,
+// whether it expired or not.
+func (r *userRepository) GetUserToken(purpose, tokenHash string) (*model.UserToken, error) {
+	var token model.UserToken
+	err := r.db.Where("purpose = ? AND token_hash = ? AND used_at IS NULL", purpose, tokenHash).First(&token).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:GetUserToken] ", err)
+			return nil, myerrors.ErrRecordNotFound
+		}
+		log.Println("[Repo:GetUserToken] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+	return &token, nil
+}
+
+// UseUserToken marks an unused token of the given purpose as used. It returns
+// myerrors.ErrRecordNotFound if the token is unknown or was used and
+// myerrors.ErrExpired if it expired.
+func (r *userRepository) UseUserToken(purpose, tokenHash string) (*model.UserToken, error) {
+	var token *model.UserToken
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		token, err = useUserToken(tx, purpose, tokenHash)
+		return err
+	})
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:UseUserToken] ", err)
+			return nil, myerrors.ErrRecordNotFound
+		}
+		if err == myerrors.ErrExpired {
+			return nil, err
+		}
+		log.Println("[Repo:UseUserToken] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+
+	return token, nil
 }
+
+// RecordTokenAttempt counts a wrong code entered with a token, using the token
+// up once maxAttempts were made.
+func (r *userRepository) RecordTokenAttempt(tokenID uint, maxAttempts int) error {
+	result := r.db.Model(&model.UserToken{}).Where("id = ?", tokenID).Updates(map[string]interface{}{
+		"attempts": gorm.Expr("attempts + 1"),
+		"used_at":  gorm.Expr("CASE WHEN attempts + 1 >= ? THEN ? ELSE used_at END", maxAttempts, time.Now()),
+	})
+
+	if result.Error != nil {
+		log.Println("[Repo:RecordTokenAttempt] ", result.Error)
+		return myerrors.ErrInternalServer
+	}
+	return nil
+}
+
+// useUserToken marks an unused token of the given purpose as used, locking it so
+// that it is only ever used once.
+func useUserToken(tx *gorm.DB, purpose, tokenHash string) (*model.UserToken, error) {
+	var token model.UserToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("purpose = ? AND token_hash = ? AND used_at IS NULL", purpose, tokenHash).
+		First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(token.ExpiresAt) {
+		return nil, myerrors.ErrExpired
+	}
+	if err := tx.Model(&token).Update("used_at", now).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RecordLockout records that the logins of an email or an IP were locked.
+func (r *userRepository) RecordLockout(lockout *model.LoginLockout) error {
+	if err := r.db.Create(lockout).Error; err != nil {
+		log.Println("[Repo:RecordLockout] ", err)
+		return myerrors.ErrInternalServer
+	}
+	return nil
+}
+
+// UpdateUser applies a partial update to the profile of a user. A new email is
+// only kept as pending, asking for the current email again cancels the change.
+// It returns myerrors.ErrConflict if another user has the new email.
+func (r *userRepository) UpdateUser(userID uint, update model.UserUpdate) (*model.User, error) {
+	var user model.User
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		fields := map[string]interface{}{}
+		if update.Name != nil {
+			fields["name"] = *update.Name
+		}
+		if update.Email != nil {
+			if *update.Email == user.Email {
+				// The links sent for a previous change stop working
+				if err := tx.Where("user_id = ? AND purpose = ?", userID, model.TokenEmailChange).Delete(&model.UserToken{}).Error; err != nil {
+					return err
+				}
+				fields["pending_email"] = ""
+			} else {
+				var count int64
+				if err := tx.Model(&model.User{}).Where("email = ? AND id <> ?", *update.Email, userID).Count(&count).Error; err != nil {
+					return err
+				}
+				if count > 0 {
+					return myerrors.ErrConflict
+				}
+				fields["pending_email"] = *update.Email
+			}
+		}
+
+		if len(fields) == 0 {
+			return nil
+		}
+		return tx.Model(&user).Updates(fields).Error
+	})
+
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			log.Println("[Repo:UpdateUser] ", err)
+			return nil, myerrors.ErrRecordNotFound
+		case myerrors.ErrConflict:
+			return nil, err
+		default:
+			log.Println("[Repo:UpdateUser] ", err)
+			return nil, myerrors.ErrInternalServer
+		}
+	}
+
+	return &user, nil
+}
+
+// ChangeEmail replaces the email of a user with the pending email the token was
+// sent to, which is verified by then. The links sent to the previous email stop
+// working. It returns myerrors.ErrConflict if another user took the email meanwhile.
+func (r *userRepository) ChangeEmail(tokenHash string) (uint, error) {
+	var userID uint
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		token, err := useUserToken(tx, model.TokenEmailChange, tokenHash)
+		if err != nil {
+			return err
+		}
+		userID = token.UserID
+
+		var user model.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, token.UserID).Error; err != nil {
+			return err
+		}
+		if user.PendingEmail == "" {
+			return gorm.ErrRecordNotFound
+		}
+
+		var count int64
+		if err := tx.Model(&model.User{}).Where("email = ? AND id <> ?", user.PendingEmail, user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return myerrors.ErrConflict
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.UserToken{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"email":          user.PendingEmail,
+			"pending_email":  "",
+			"email_verified": true,
+		}).Error
+	})
+
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			log.Println("[Repo:ChangeEmail] ", err)
+			return 0, myerrors.ErrRecordNotFound
+		case myerrors.ErrExpired, myerrors.ErrConflict:
+			return 0, err
+		default:
+			log.Println("[Repo:ChangeEmail] ", err)
+			return 0, myerrors.ErrInternalServer
+		}
+	}
+
+	return userID, nil
+}
+
+// UpdatePassword sets the password hash of a user. Password reset links sent
+// before stop working.
+func (r *userRepository) UpdatePassword(userID uint, passwordHash string) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("id = ?", userID).Update("password_hash", passwordHash).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND purpose = ?", userID, model.TokenPasswordReset).Delete(&model.UserToken{}).Error
+	})
+
+	if err != nil {
+		log.Println("[Repo:UpdatePassword] ", err)
+		return myerrors.ErrInternalServer
+	}
+	return nil
+}
+
+// DeleteUser permanently deletes a user with all of their notes, tags and notebooks.
+// The revisions, shares and public links of the notes, the notes shared with the
+// user and their tokens go with them through the foreign keys.
+func (r *userRepository) DeleteUser(userID uint) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&model.Note{}).Error; err != nil {
+			return err
+		}
+		// Nobody syncs the notes of the user anymore, drop the tombstones written above
+		if err := tx.Where("user_id = ?", userID).Delete(&model.NoteTombstone{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&model.Tag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&model.Notebook{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Delete(&model.User{}, userID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			log.Println("[Repo:DeleteUser] ", err)
+			return myerrors.ErrRecordNotFound
+		}
+		log.Println("[Repo:DeleteUser] ", err)
+		return myerrors.ErrInternalServer
+	}
+	return nil
+}
+
+// RevokeAllSessions deletes every session of the given user.
+func (r *userRepository) RevokeAllSessions(userID uint) error {
+	return r.sessions.RevokeAllOfUser(userID)
+}
+
+// RevokeOtherSessions deletes every session of the given user but the one with the given SID.
+func (r *userRepository) RevokeOtherSessions(userID uint, sid string) error {
+	sessions, err := r.sessions.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.SID == sid {
+			continue
+		}
+		if err := r.sessions.Revoke(session.SID); err != nil && err != myerrors.ErrRecordNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rest of the UserRepository methods...
@@ -0,0 +1,62 @@
+// Package secrets encrypts the secrets kept in the database, so that a leaked
+// database does not leak them without the key.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// KeySize is the size of keys in bytes, for AES-256.
+const KeySize = 32
+
+// ErrInvalid is returned when a sealed secret was tampered with or sealed with another key.
+var ErrInvalid = errors.New("secrets: invalid sealed secret")
+
+// Box seals and opens secrets with AES-GCM.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox creates a Box with a key of KeySize bytes.
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("secrets: the key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead}, nil
+}
+
+// Seal encrypts a secret, returning the random nonce and the ciphertext in base64.
+func (b *Box) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret returned by Seal.
+func (b *Box) Open(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < b.aead.NonceSize() {
+		return "", ErrInvalid
+	}
+	nonce, ciphertext := data[:b.aead.NonceSize()], data[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,34 @@
+package secrets
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	box, err := NewBox(bytes.Repeat([]byte{1}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := box.Seal("JBSWY3DPEHPK3PXP"); again == sealed {
+		t.Error("sealing twice gave the same ciphertext")
+	}
+
+	got, err := box.Open(sealed)
+	if err != nil || got != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("Open = %q, %v", got, err)
+	}
+
+	other, _ := NewBox(bytes.Repeat([]byte{2}, KeySize))
+	if _, err := other.Open(sealed); err != ErrInvalid {
+		t.Errorf("Open with another key = %v, want ErrInvalid", err)
+	}
+	if _, err := NewBox([]byte("short")); err == nil {
+		t.Error("short key accepted")
+	}
+}
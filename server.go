@@ -1,16 +1,22 @@
 package main
 
 import (
+	"accuknox/config"
 	"accuknox/handler"
+	"accuknox/mailer"
 	"accuknox/model"
+	"accuknox/myerrors"
 	"accuknox/repository"
+	"accuknox/secrets"
 	"accuknox/service"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -42,50 +48,242 @@ func main() {
 		dbHost, dbPort, dbName, dbUser, dbPassword,
 	)
 
+	cfg := config.LoadConfig(dbConnectionString)
+
+	// Only believe the client IPs forwarded by known proxies, login limits are kept per IP
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Println(err)
+		panic("Invalid TRUSTED_PROXIES")
+	}
+
 	// Initialize database connection
 	db, err := gorm.Open(postgres.Open(dbConnectionString), &gorm.Config{})
 	if err != nil {
 		panic("Failed to connect to the database")
 	}
 
-	db.AutoMigrate(&model.Note{}, &model.User{}, &model.UserSession{})
+	// Sessions are kept in the session store and need no table
+	if err := repository.MigrateEmailVerified(db); err != nil {
+		panic("Failed to migrate the email verification of users")
+	}
+	db.AutoMigrate(&model.Note{}, &model.NoteRevision{}, &model.Tag{}, &model.Notebook{}, &model.User{}, &model.NoteShare{}, &model.PublicLink{}, &model.NoteTombstone{}, &model.UserToken{}, &model.RecoveryCode{}, &model.LoginLockout{})
+	if err := repository.MigrateNoteSearch(db); err != nil {
+		panic("Failed to migrate the notes search index")
+	}
+	if err := repository.MigrateNoteSync(db); err != nil {
+		panic("Failed to migrate the notes sync triggers")
+	}
 
-	rClient := redis.NewClient(&redis.Options{
-		Addr: fmt.Sprintf("%v:6379", redisHost),
-		DB:   0,
-	})
+	// Connect to redis if sessions, events or failed logins go through it
+	var rClient *redis.Client
+	if cfg.SessionStore == config.SessionStoreRedis || cfg.EventBus == config.EventBusRedis || cfg.LoginThrottle == config.LoginThrottleRedis {
+		rClient = redis.NewClient(&redis.Options{
+			Addr: fmt.Sprintf("%v:6379", redisHost),
+			DB:   0,
+		})
 
-	_, err = rClient.Ping().Result()
-	if err != nil {
-		log.Println(err)
-		panic("Failed to connect to redis")
+		_, err = rClient.Ping().Result()
+		if err != nil {
+			log.Println(err)
+			panic("Failed to connect to redis")
+		}
+	}
+
+	// Initialize the session store selected by the configuration
+	var sessionStore repository.SessionStore
+	switch cfg.SessionStore {
+	case config.SessionStoreMemory:
+		sessionStore = repository.NewMemorySessionStore(cfg.SessionIdleTimeout, cfg.SessionMaxLifetime)
+	case config.SessionStoreRedis:
+		sessionStore = repository.NewRedisSessionStore(rClient, cfg.SessionIdleTimeout, cfg.SessionMaxLifetime)
+	default:
+		panic(fmt.Sprintf("Unknown session store %q", cfg.SessionStore))
+	}
+
+	// Initialize the event bus selected by the configuration
+	var eventBus repository.EventBus
+	switch cfg.EventBus {
+	case config.EventBusMemory:
+		eventBus = repository.NewMemoryEventBus(cfg.EventRetention)
+	case config.EventBusRedis:
+		eventBus = repository.NewRedisEventBus(rClient, cfg.EventRetention)
+	default:
+		panic(fmt.Sprintf("Unknown event bus %q", cfg.EventBus))
+	}
+
+	// Initialize the login throttle selected by the configuration
+	var loginThrottle repository.LoginThrottle
+	switch cfg.LoginThrottle {
+	case config.LoginThrottleMemory:
+		loginThrottle = repository.NewMemoryLoginThrottle(cfg.LoginFailureWindow)
+	case config.LoginThrottleRedis:
+		loginThrottle = repository.NewRedisLoginThrottle(rClient, cfg.LoginFailureWindow)
+	default:
+		panic(fmt.Sprintf("Unknown login throttle %q", cfg.LoginThrottle))
+	}
+
+	// Check the limits of unverified accounts before the routes rely on them
+	switch cfg.UnverifiedPolicy {
+	case config.UnverifiedAllow, config.UnverifiedNoShare, config.UnverifiedReadOnly:
+	default:
+		panic(fmt.Sprintf("Unknown unverified policy %q", cfg.UnverifiedPolicy))
+	}
+
+	// Initialize the mailer selected by the configuration
+	var mail mailer.Mailer
+	switch cfg.Mailer {
+	case config.MailerLog:
+		mail = mailer.NewLogMailer(log.Writer(), cfg.MailFrom)
+	case config.MailerFile:
+		mailFile, err := os.OpenFile(cfg.MailFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Println(err)
+			panic("Failed to open the mail file")
+		}
+		defer mailFile.Close()
+		mail = mailer.NewLogMailer(mailFile, cfg.MailFrom)
+	default:
+		panic(fmt.Sprintf("Unknown mailer %q", cfg.Mailer))
+	}
+
+	// Two-factor secrets are encrypted with the configured key
+	var twoFactorBox *secrets.Box
+	if cfg.TwoFactorKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.TwoFactorKey)
+		if err == nil {
+			twoFactorBox, err = secrets.NewBox(key)
+		}
+		if err != nil {
+			log.Println(err)
+			panic("Invalid TWO_FACTOR_KEY")
+		}
+	} else {
+		log.Println("TWO_FACTOR_KEY is not set, two-factor authentication cannot be set up")
 	}
 
 	// Initialize repository implementations
-	userRepo := repository.NewUserRepository(db, rClient)
+	userRepo := repository.NewUserRepository(db, sessionStore)
 	noteRepo := repository.NewNoteRepository(db)
+	tagRepo := repository.NewTagRepository(db)
+	notebookRepo := repository.NewNotebookRepository(db)
+	shareRepo := repository.NewShareRepository(db)
+	linkRepo := repository.NewPublicLinkRepository(db)
 
 	// Initialize service implementations with repositories
-	userService := service.NewUserService(userRepo)
-	noteService := service.NewNoteService(noteRepo)
+	loginGuard := service.NewLoginGuard(loginThrottle, userRepo, service.LoginLimits{
+		DelayAfter:       cfg.LoginDelayAfter,
+		BaseDelay:        cfg.LoginBaseDelay,
+		MaxFailures:      cfg.LoginMaxFailures,
+		MaxFailuresPerIP: cfg.LoginMaxFailuresPerIP,
+		Lockout:          cfg.LoginLockout,
+	})
+	twoFactorService := service.NewTwoFactorService(userRepo, loginGuard, twoFactorBox, cfg.TwoFactorIssuer, cfg.TwoFactorChallengeTTL)
+	userService := service.NewUserService(userRepo, twoFactorService, loginGuard, mail, cfg.AppURL, cfg.PasswordResetTTL, cfg.EmailVerificationTTL)
+	noteService := service.NewNoteService(noteRepo, shareRepo, eventBus, cfg.SyncTombstoneRetention)
+	tagService := service.NewTagService(tagRepo, shareRepo, eventBus)
+	notebookService := service.NewNotebookService(notebookRepo, shareRepo, eventBus)
+	shareService := service.NewShareService(shareRepo, noteRepo, userRepo)
+	linkService := service.NewPublicLinkService(linkRepo, noteRepo, shareRepo, loginThrottle, cfg.LoginMaxFailures, cfg.LoginLockout)
 
 	// Initialize handler implementations with services
-	userHandler := handler.NewUserHandler(userService)
+	userHandler := handler.NewUserHandler(userService, cfg)
+	twoFactorHandler := handler.NewTwoFactorHandler(twoFactorService, cfg)
 	noteHandler := handler.NewNoteHandler(noteService)
+	tagHandler := handler.NewTagHandler(tagService)
+	notebookHandler := handler.NewNotebookHandler(notebookService)
+	shareHandler := handler.NewShareHandler(shareService)
+	linkHandler := handler.NewPublicLinkHandler(linkService)
+
+	sessionService := service.NewSessionService(sessionStore)
+	sessionHandler := handler.NewSessionHandler(sessionService, cfg)
+
+	eventService := service.NewEventService(eventBus)
+	eventHandler := handler.NewEventHandler(eventService, sessionService)
 
-	sessionService := service.NewSessionService(userRepo)
+	collabService := service.NewCollabService(noteRepo, shareRepo, userRepo, eventBus, cfg.CollabSaveInterval, cfg.CollabIdleTimeout)
+	collabHandler := handler.NewCollabHandler(collabService, sessionService)
+
+	authorized := authorizeMiddleware(sessionService, cfg)
+
+	// Limits of the accounts whose email is not verified, depending on the policy
+	canWrite := verifiedEmailMiddleware(userService, cfg, config.UnverifiedReadOnly)
+	canShare := verifiedEmailMiddleware(userService, cfg, config.UnverifiedReadOnly, config.UnverifiedNoShare)
 
 	// Register routes using the handler implementations
 	v1 := router.Group("/v1")
 	{
-		// Public endpoints (signup and login)
+		// Public endpoints (signup, login, password reset, email verification and public links)
 		v1.POST("/signup", userHandler.SignUpHandler)
 		v1.POST("/login", userHandler.LoginHandler)
+		v1.POST("/login/2fa", twoFactorHandler.LoginHandler)
+		v1.POST("/password/forgot", userHandler.ForgotPasswordHandler)
+		v1.POST("/password/reset", userHandler.ResetPasswordHandler)
+		v1.POST("/email/verify", userHandler.VerifyEmailHandler)
+		v1.GET("/public/:token", linkHandler.ViewPublicNoteHandler)
+
+		// Account-related endpoints that require authorization
+		v1.GET("/me", authorized, userHandler.GetProfileHandler)
+		v1.PATCH("/me", authorized, userHandler.UpdateProfileHandler)
+		v1.DELETE("/me", authorized, userHandler.DeleteAccountHandler)
+		v1.POST("/me/password", authorized, userHandler.ChangePasswordHandler)
+		v1.POST("/email/verify/resend", authorized, userHandler.ResendVerificationHandler)
+		v1.POST("/2fa/setup", authorized, twoFactorHandler.SetupHandler)
+		v1.POST("/2fa/confirm", authorized, twoFactorHandler.ConfirmHandler)
+		v1.POST("/2fa/disable", authorized, twoFactorHandler.DisableHandler)
+
+		// Session-related endpoints that require authorization
+		v1.POST("/logout", authorized, sessionHandler.LogoutHandler)
+		v1.POST("/logout-all", authorized, sessionHandler.LogoutAllHandler)
+		v1.GET("/sessions", authorized, sessionHandler.GetSessionsHandler)
+		v1.DELETE("/sessions/:id", authorized, sessionHandler.DeleteSessionHandler)
 
 		// Notes-related endpoints that require authorization
-		v1.POST("/notes", authorizeMiddleware(sessionService), noteHandler.CreateNoteHandler)
-		v1.GET("/notes", authorizeMiddleware(sessionService), noteHandler.GetAllUserNotesHandler)
-		v1.DELETE("/notes", authorizeMiddleware(sessionService), noteHandler.DeleteNoteHandler)
+		v1.POST("/notes", authorized, canWrite, noteHandler.CreateNoteHandler)
+		v1.GET("/notes", authorized, noteHandler.GetAllUserNotesHandler)
+		v1.GET("/notes/search", authorized, noteHandler.SearchNotesHandler)
+		v1.GET("/notes/:id", authorized, noteHandler.GetNoteHandler)
+		v1.PATCH("/notes/:id", authorized, canWrite, noteHandler.UpdateNoteHandler)
+		v1.DELETE("/notes", authorized, canWrite, noteHandler.DeleteNoteHandler)
+		v1.POST("/notes/:id/restore", authorized, canWrite, noteHandler.RestoreNoteHandler)
+		v1.GET("/notes/:id/revisions", authorized, noteHandler.GetRevisionsHandler)
+		v1.GET("/notes/:id/revisions/diff", authorized, noteHandler.DiffRevisionsHandler)
+		v1.GET("/notes/:id/revisions/:rev", authorized, noteHandler.GetRevisionHandler)
+		v1.POST("/notes/:id/revisions/:rev/restore", authorized, canWrite, noteHandler.RestoreRevisionHandler)
+		v1.GET("/notes/:id/collab", authorized, canWrite, collabHandler.CollabHandler)
+
+		// Stream of the changes of the notes the user has access to
+		v1.GET("/events", authorized, eventHandler.StreamEventsHandler)
+
+		// Sharing-related endpoints that require authorization
+		v1.GET("/notes/shared-with-me", authorized, shareHandler.GetSharedWithMeHandler)
+		v1.POST("/notes/:id/shares", authorized, canShare, shareHandler.CreateShareHandler)
+		v1.GET("/notes/:id/shares", authorized, shareHandler.GetSharesHandler)
+		v1.DELETE("/notes/:id/shares/:shareId", authorized, shareHandler.DeleteShareHandler)
+
+		// Public link-related endpoints that require authorization
+		v1.POST("/notes/:id/links", authorized, canShare, linkHandler.CreateLinkHandler)
+		v1.GET("/notes/:id/links", authorized, linkHandler.GetLinksHandler)
+		v1.DELETE("/notes/:id/links/:linkId", authorized, linkHandler.DeleteLinkHandler)
+
+		// Trash-related endpoints that require authorization
+		v1.GET("/trash", authorized, noteHandler.GetTrashHandler)
+		v1.DELETE("/trash", authorized, canWrite, noteHandler.EmptyTrashHandler)
+
+		// Sync-related endpoints that require authorization
+		v1.GET("/sync", authorized, noteHandler.SyncPullHandler)
+		v1.POST("/sync", authorized, canWrite, noteHandler.SyncPushHandler)
+
+		// Tag-related endpoints that require authorization
+		v1.GET("/tags", authorized, tagHandler.GetTagsHandler)
+		v1.PATCH("/tags/:id", authorized, canWrite, tagHandler.RenameTagHandler)
+		v1.DELETE("/tags/:id", authorized, canWrite, tagHandler.DeleteTagHandler)
+
+		// Notebook-related endpoints that require authorization
+		v1.POST("/notebooks", authorized, canWrite, notebookHandler.CreateNotebookHandler)
+		v1.GET("/notebooks", authorized, notebookHandler.GetNotebooksHandler)
+		v1.GET("/notebooks/:id", authorized, notebookHandler.GetNotebookHandler)
+		v1.PATCH("/notebooks/:id", authorized, canWrite, notebookHandler.UpdateNotebookHandler)
+		v1.DELETE("/notebooks/:id", authorized, canWrite, notebookHandler.DeleteNotebookHandler)
 		// Add more routes as needed
 	}
 	// Create a context with cancellation support
@@ -107,6 +305,13 @@ func main() {
 		}
 	}()
 
+	// Start the purger of the trash in a separate goroutine
+	wg.Add(1)
+	go func(ctx context.Context) {
+		defer wg.Done()
+		runTrashPurger(ctx, noteService, cfg.TrashRetention, cfg.SyncTombstoneRetention, cfg.TrashPurgeInterval)
+	}(ctx)
+
 	// Listen for OS signals to initiate graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
@@ -115,6 +320,13 @@ func main() {
 	// Cancel the context to initiate shutdown
 	cancel()
 
+	// Save the notes being edited together and end the editing sessions and
+	// event streams, which would otherwise hold the shutdown up
+	collabService.Close()
+	if err := eventBus.Close(); err != nil {
+		fmt.Printf("Event bus shutdown error: %v\n", err)
+	}
+
 	// Give some time for ongoing requests to finish (adjust as needed)
 	timeout := 15 * time.Second
 	ctx, cancel = context.WithTimeout(context.Background(), timeout)
@@ -132,27 +344,124 @@ func main() {
 }
 
 // authorizeMiddleware is a custom middleware to check the session ID (SID) for authorization.
-func authorizeMiddleware(sessionService service.SessionService) gin.HandlerFunc {
+func authorizeMiddleware(sessionService service.SessionService, cfg config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Inside your handler where you need to extract the SID
-		var request dto.AuthRequest
-
-		if err := c.ShouldBindBodyWith(&request, binding.JSON); err != nil {
-			log.Println("[authorizeMiddleware] ", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		sid := sessionToken(c, cfg)
+		if sid == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
 			return
 		}
 
-		sid := request.SID
-
 		// Check if the session is valid using the SessionService
-		userId, valid := sessionService.IsValidSession(sid)
-		if valid {
-			c.Set("userId", userId) // Store the session ID in the context for later use
+		userId, err := sessionService.ValidateSession(sid)
+		switch err {
+		case nil:
+			// Record the activity and slide the idle expiry, a failure here must not block the request
+			if err := sessionService.TouchSession(sid); err != nil {
+				log.Println("[authorizeMiddleware] ", err)
+			}
+
+			c.Set("userId", userId) // Store the user ID in the context for later use
+			c.Set("sid", sid)       // Store the session ID so the session can be revoked
 			c.Next()                // Continue to the next middleware or handler
-		} else {
+		case myerrors.ErrSessionExpired:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+			c.Abort() // Abort further processing
+		case myerrors.ErrUnauthorized:
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			c.Abort() // Abort further processing
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate session"})
+			c.Abort() // Abort further processing
+		}
+	}
+}
+
+// verifiedEmailMiddleware rejects the requests of users whose email is not verified
+// when the configured policy is one of the given policies.
+func verifiedEmailMiddleware(userService service.UserService, cfg config.Config, policies ...string) gin.HandlerFunc {
+	restricted := false
+	for _, policy := range policies {
+		if policy == cfg.UnverifiedPolicy {
+			restricted = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		if !restricted {
+			c.Next()
+			return
+		}
+
+		userId, _ := c.Get("userId")
+		user, err := userService.GetUser(userId.(uint))
+		switch {
+		case err == myerrors.ErrRecordNotFound:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+			c.Abort()
+		case !user.EmailVerified:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email not verified"})
+			c.Abort()
+		default:
+			c.Next()
+		}
+	}
+}
+
+// sessionToken extracts the SID from the request, trying the configured sources in order.
+func sessionToken(c *gin.Context, cfg config.Config) string {
+	for _, source := range cfg.SessionTokenSources {
+		switch source {
+		case config.TokenSourceHeader:
+			auth := c.GetHeader("Authorization")
+			if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+				return strings.TrimSpace(auth[len("Bearer "):])
+			}
+		case config.TokenSourceCookie:
+			if sid, err := c.Cookie(cfg.SessionCookieName); err == nil && sid != "" {
+				return sid
+			}
+		case config.TokenSourceBody:
+			// Deprecated, kept for clients that still send the SID in the JSON body
+			var request dto.AuthRequest
+			if c.Request.ContentLength != 0 && c.ShouldBindBodyWith(&request, binding.JSON) == nil && request.SID != "" {
+				c.Header("Deprecation", "true")
+				return request.SID
+			}
+		}
+	}
+	return ""
+}
+
+// runTrashPurger permanently deletes the notes that stayed in the trash longer than
+// the retention period, and the tombstones of the deleted notes older than theirs,
+// checking every interval until the context is cancelled.
+func runTrashPurger(ctx context.Context, noteService service.NoteService, retention, tombstoneRetention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := noteService.PurgeTrashedNotes(time.Now().Add(-retention))
+		if err != nil {
+			log.Println("[runTrashPurger] ", err)
+		} else if purged > 0 {
+			log.Printf("[runTrashPurger] purged %d notes from the trash\n", purged)
+		}
+		pruned, err := noteService.PurgeTombstones(time.Now().Add(-tombstoneRetention))
+		if err != nil {
+			log.Println("[runTrashPurger] ", err)
+		} else if pruned > 0 {
+			log.Printf("[runTrashPurger] pruned %d sync tombstones\n", pruned)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 	}
 }
@@ -0,0 +1,634 @@
+package service
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"accuknox/ot"
+	"accuknox/repository"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Limits of collaborative editing sessions.
+const (
+	// collabHistorySize is the number of operations kept to transform late
+	// operations against and to catch reconnecting clients up.
+	collabHistorySize = 1000
+	// collabSendBuffer is the number of messages a client may fall behind before
+	// it is dropped, it then reconnects and catches up.
+	collabSendBuffer = 256
+	// collabSaveAttempts bounds the saves retried after merging concurrent changes.
+	collabSaveAttempts = 3
+)
+
+// CollabService provides methods for editing notes together in real time.
+//
+// The clients editing a note share a session in which their operations are
+// transformed against each other so that every client converges to the same
+// content. Sessions live in the instance serving them, so the clients of a note
+// must be served by the same instance.
+type CollabService interface {
+	// Join adds the user to the editing session of a note. A client reconnecting
+	// with the ID of its session and the last revision it knows catches up from there.
+	Join(userID, noteID uint, sessionID string, revision int) (*CollabClient, error)
+	// Close saves the content of all sessions and disconnects their clients.
+	Close()
+}
+
+// CollabClient is a client taking part in an editing session.
+type CollabClient struct {
+	ID       string
+	Name     string
+	ReadOnly bool
+	userID   uint
+	send     chan model.CollabMessage
+	room     *collabRoom
+}
+
+// Messages returns the channel of the messages for the client. It is closed
+// once the client left or was dropped from the session.
+func (c *CollabClient) Messages() <-chan model.CollabMessage {
+	return c.send
+}
+
+// Submit handles a message of the client.
+func (c *CollabClient) Submit(msg model.CollabMessage) {
+	c.room.submit(c, msg)
+}
+
+// Leave removes the client from the session, it may be called more than once.
+func (c *CollabClient) Leave() {
+	c.room.leave(c)
+}
+
+// Reauthorize checks again the access of the user of the client to the note. A
+// client whose user can no longer read the note, or edit it if the client could,
+// is dropped from the session and may join again with the access left.
+func (c *CollabClient) Reauthorize() {
+	c.room.reauthorize(c)
+}
+
+type collabService struct {
+	noteRepo     repository.NoteRepository
+	shareRepo    repository.ShareRepository
+	userRepo     repository.UserRepository
+	events       repository.EventBus
+	saveInterval time.Duration
+	idleTimeout  time.Duration
+
+	mu     sync.Mutex
+	rooms  map[uint]*collabRoom
+	closed bool
+}
+
+// NewCollabService creates a new CollabService saving the content of the notes
+// edited together every saveInterval and ending sessions idleTimeout after their last client left.
+func NewCollabService(noteRepo repository.NoteRepository, shareRepo repository.ShareRepository, userRepo repository.UserRepository,
+	events repository.EventBus, saveInterval, idleTimeout time.Duration) CollabService {
+	return &collabService{
+		noteRepo:     noteRepo,
+		shareRepo:    shareRepo,
+		userRepo:     userRepo,
+		events:       events,
+		saveInterval: saveInterval,
+		idleTimeout:  idleTimeout,
+		rooms:        make(map[uint]*collabRoom),
+	}
+}
+
+// Join adds the user to the editing session of a note they may read, users
+// without the edit permission only follow the changes.
+func (s *collabService) Join(userID, noteID uint, sessionID string, revision int) (*CollabClient, error) {
+	note, err := authorizeNote(s.noteRepo, s.shareRepo, userID, noteID, model.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	permission, err := notePermission(s.shareRepo, userID, note)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &CollabClient{
+		ID:       uuid.New().String(),
+		Name:     user.Name,
+		ReadOnly: permissionLevels[permission] < permissionLevels[model.PermissionEdit],
+		userID:   userID,
+		send:     make(chan model.CollabMessage, collabSendBuffer),
+	}
+
+	// The session may end between looking it up and joining it, then start a new one
+	for {
+		room, err := s.room(note)
+		if err != nil {
+			return nil, err
+		}
+		if room.join(client, sessionID, revision) {
+			return client, nil
+		}
+	}
+}
+
+// Close saves the content of all sessions and disconnects their clients.
+func (s *collabService) Close() {
+	// Take the sessions out so that the saves do not hold up the other sessions
+	s.mu.Lock()
+	rooms := s.rooms
+	s.rooms = make(map[uint]*collabRoom)
+	s.closed = true
+	s.mu.Unlock()
+
+	for _, room := range rooms {
+		room.mu.Lock()
+		room.save()
+		room.end()
+		room.mu.Unlock()
+	}
+}
+
+// room returns the session of a note, starting it with the stored note if needed.
+func (s *collabService) room(note *model.Note) (*collabRoom, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, myerrors.ErrInternalServer
+	}
+
+	if room, ok := s.rooms[note.ID]; ok && !room.isClosed() {
+		return room, nil
+	}
+
+	room := &collabRoom{
+		svc:          s,
+		noteID:       note.ID,
+		ownerID:      note.UserID,
+		sessionID:    uuid.New().String(),
+		content:      note.Content,
+		clients:      make(map[string]*CollabClient),
+		cursors:      make(map[string]*model.CollabCursor),
+		savedContent: note.Content,
+		savedVersion: note.Version,
+	}
+	s.rooms[note.ID] = room
+	return room, nil
+}
+
+// closeIdle ends a session whose clients all left, saving its content first.
+func (s *collabService) closeIdle(room *collabRoom) {
+	// Closing the session first keeps clients from joining it, they start a new
+	// one instead, which merges the content saved below on its first save
+	room.mu.Lock()
+	if room.closed || len(room.clients) > 0 {
+		room.mu.Unlock()
+		return
+	}
+	room.closed = true
+	room.mu.Unlock()
+
+	// Take the session out before saving so that the save does not hold up the
+	// other sessions
+	s.mu.Lock()
+	if s.rooms[room.noteID] == room {
+		delete(s.rooms, room.noteID)
+	}
+	s.mu.Unlock()
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.save()
+	room.end()
+}
+
+// collabEntry is an operation applied in a session.
+type collabEntry struct {
+	op       ot.Op
+	opID     string
+	clientID string
+}
+
+// collabRoom is the editing session of a note.
+type collabRoom struct {
+	svc       *collabService
+	noteID    uint
+	ownerID   uint
+	sessionID string
+
+	mu      sync.Mutex
+	content string
+	base    int // revision reached before the first operation of history
+	history []collabEntry
+	clients map[string]*CollabClient
+	cursors map[string]*model.CollabCursor
+
+	// The content last saved, at savedVersion, and the operations applied since
+	savedContent string
+	savedVersion uint
+	unsaved      []ot.Op
+	// amend tells that the last revision of the note was recorded by the session,
+	// saves then update it rather than add a revision every save interval
+	amend bool
+
+	saveTimer *time.Timer
+	idleTimer *time.Timer
+	closed    bool
+}
+
+func (r *collabRoom) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// revision returns the number of operations applied in the session.
+func (r *collabRoom) revision() int {
+	return r.base + len(r.history)
+}
+
+// join adds a client to the session, it returns false if the session ended.
+func (r *collabRoom) join(client *CollabClient, sessionID string, since int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return false
+	}
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+
+	client.room = r
+	init := model.CollabMessage{
+		Type:      model.CollabInit,
+		SessionID: r.sessionID,
+		ClientID:  client.ID,
+		Name:      client.Name,
+		ReadOnly:  client.ReadOnly,
+		Clients:   r.collaborators(),
+	}
+	r.clients[client.ID] = client
+
+	// Catch a reconnecting client up with the operations it missed, or else send the content
+	if sessionID == r.sessionID && since >= r.base && since <= r.revision() && r.revision()-since <= collabSendBuffer/2 {
+		init.Revision = since
+		r.send(client, init)
+		for i, entry := range r.history[since-r.base:] {
+			r.send(client, model.CollabMessage{
+				Type:     model.CollabOp,
+				ClientID: entry.clientID,
+				Revision: since + i + 1,
+				OpID:     entry.opID,
+				Op:       entry.op,
+			})
+		}
+	} else {
+		content := r.content
+		init.Revision = r.revision()
+		init.Content = &content
+		r.send(client, init)
+	}
+
+	r.broadcast(client, model.CollabMessage{
+		Type:     model.CollabJoin,
+		ClientID: client.ID,
+		Name:     client.Name,
+		Revision: r.revision(),
+		ReadOnly: client.ReadOnly,
+	})
+	return true
+}
+
+// leave removes a client from the session, saving the content if it was the last one.
+func (r *collabRoom) leave(client *CollabClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.remove(client) && len(r.clients) == 0 {
+		r.save()
+	}
+}
+
+// reauthorize drops a client whose user lost their access to the note. Errors
+// looking the access up keep the client, it is checked again later.
+func (r *collabRoom) reauthorize(client *CollabClient) {
+	permission := ""
+	note, err := r.svc.noteRepo.FindNoteByID(r.noteID)
+	if err == nil {
+		permission, err = notePermission(r.svc.shareRepo, client.userID, note)
+	}
+	if err != nil && err != myerrors.ErrRecordNotFound {
+		log.Println("[Service:CollabReauthorize] ", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.clients[client.ID] != client {
+		return
+	}
+	needed := model.PermissionEdit
+	if client.ReadOnly {
+		needed = model.PermissionRead
+	}
+	if permissionLevels[permission] >= permissionLevels[needed] {
+		return
+	}
+
+	r.send(client, model.CollabMessage{Type: model.CollabError, Revision: r.revision(), Error: "Access to the note changed, rejoin the session"})
+	r.remove(client)
+}
+
+// submit handles a message of a client.
+func (r *collabRoom) submit(client *CollabClient, msg model.CollabMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.clients[client.ID] != client {
+		return
+	}
+
+	switch msg.Type {
+	case model.CollabOp:
+		r.applyOp(client, msg)
+	case model.CollabMoved:
+		r.moveCursor(client, msg)
+	default:
+		r.reject(client, msg, "Unknown message type")
+	}
+}
+
+// applyOp applies an operation of a client, transformed against the operations
+// applied since the revision it is based on.
+func (r *collabRoom) applyOp(client *CollabClient, msg model.CollabMessage) {
+	if client.ReadOnly {
+		r.reject(client, msg, "Read-only access")
+		return
+	}
+
+	// A client resending an operation after reconnecting is only acknowledged again
+	if msg.OpID != "" {
+		for i, entry := range r.history {
+			if entry.opID == msg.OpID {
+				r.send(client, model.CollabMessage{Type: model.CollabAck, Revision: r.base + i + 1, OpID: msg.OpID})
+				return
+			}
+		}
+	}
+
+	if msg.Revision < r.base || msg.Revision > r.revision() {
+		r.reject(client, msg, "Unknown revision, rejoin the session")
+		return
+	}
+
+	op := msg.Op
+	for _, entry := range r.history[msg.Revision-r.base:] {
+		var err error
+		if op, _, err = ot.Transform(op, entry.op); err != nil {
+			r.reject(client, msg, "Operation does not match the revision")
+			return
+		}
+	}
+	content, err := ot.Apply(r.content, op)
+	if err != nil {
+		r.reject(client, msg, "Operation does not match the revision")
+		return
+	}
+
+	r.record(op, msg.OpID, client.ID, content)
+	r.send(client, model.CollabMessage{Type: model.CollabAck, Revision: r.revision(), OpID: msg.OpID})
+	r.broadcast(client, model.CollabMessage{
+		Type:     model.CollabOp,
+		ClientID: client.ID,
+		Revision: r.revision(),
+		OpID:     msg.OpID,
+		Op:       op,
+	})
+	r.scheduleSave()
+}
+
+// moveCursor records the cursor of a client and shows it to the other clients.
+func (r *collabRoom) moveCursor(client *CollabClient, msg model.CollabMessage) {
+	if msg.Cursor == nil || msg.Revision < r.base || msg.Revision > r.revision() {
+		r.reject(client, msg, "Invalid cursor")
+		return
+	}
+
+	cursor := *msg.Cursor
+	for _, entry := range r.history[msg.Revision-r.base:] {
+		cursor.Position = ot.TransformIndex(cursor.Position, entry.op)
+		cursor.SelectionEnd = ot.TransformIndex(cursor.SelectionEnd, entry.op)
+	}
+	length := utf8.RuneCountInString(r.content)
+	cursor.Position = clampIndex(cursor.Position, length)
+	cursor.SelectionEnd = clampIndex(cursor.SelectionEnd, length)
+	r.cursors[client.ID] = &cursor
+
+	r.broadcast(client, model.CollabMessage{
+		Type:     model.CollabMoved,
+		ClientID: client.ID,
+		Name:     client.Name,
+		Revision: r.revision(),
+		Cursor:   &cursor,
+	})
+}
+
+// record appends an applied operation to the history and moves the cursors accordingly.
+func (r *collabRoom) record(op ot.Op, opID, clientID, content string) {
+	r.content = content
+	r.unsaved = append(r.unsaved, op)
+	r.history = append(r.history, collabEntry{op: op, opID: opID, clientID: clientID})
+	if extra := len(r.history) - collabHistorySize; extra > 0 {
+		r.history = append([]collabEntry(nil), r.history[extra:]...)
+		r.base += extra
+	}
+
+	for _, cursor := range r.cursors {
+		cursor.Position = ot.TransformIndex(cursor.Position, op)
+		cursor.SelectionEnd = ot.TransformIndex(cursor.SelectionEnd, op)
+	}
+}
+
+// scheduleSave saves the content after the save interval, unless a save is already scheduled.
+func (r *collabRoom) scheduleSave() {
+	if r.saveTimer != nil {
+		return
+	}
+	r.saveTimer = time.AfterFunc(r.svc.saveInterval, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.saveTimer = nil
+		if !r.closed {
+			r.save()
+		}
+	})
+}
+
+// save stores the content of the session as a new version of the note. The saves
+// of a session make a single revision of the note, until the note is changed
+// outside of the session. That change is merged into the session as an
+// operation of the server before saving again.
+func (r *collabRoom) save() {
+	for attempt := 0; attempt < collabSaveAttempts; attempt++ {
+		if r.content == r.savedContent {
+			r.unsaved = nil
+			return
+		}
+
+		content := r.content
+		note, err := r.svc.noteRepo.UpdateNote(r.ownerID, r.noteID, model.NoteUpdate{Content: &content, Version: r.savedVersion, Amend: r.amend})
+		switch err {
+		case nil:
+			r.savedContent = content
+			r.savedVersion = note.Version
+			r.unsaved = nil
+			r.amend = true
+			publishNoteEvent(r.svc.shareRepo, r.svc.events, model.EventNoteUpdated, note)
+			return
+		case myerrors.ErrStaleVersion:
+			err = r.merge()
+		}
+
+		switch err {
+		case nil:
+		case myerrors.ErrRecordNotFound:
+			r.broadcast(nil, model.CollabMessage{Type: model.CollabError, Error: "The note was deleted"})
+			r.end()
+			return
+		default:
+			// Try again with the next operation
+			log.Println("[Service:CollabSave] ", err)
+			return
+		}
+	}
+}
+
+// merge brings a change of the stored note made outside of the session into the session.
+func (r *collabRoom) merge() error {
+	current, err := r.svc.noteRepo.FindNoteByID(r.noteID)
+	if err != nil {
+		return err
+	}
+
+	// Rebase the operations not saved yet on the stored content
+	external := ot.Replace(r.savedContent, current.Content)
+	rebased := make([]ot.Op, 0, len(r.unsaved))
+	for _, op := range r.unsaved {
+		var opPrime ot.Op
+		if external, opPrime, err = ot.Transform(external, op); err != nil {
+			return err
+		}
+		rebased = append(rebased, opPrime)
+	}
+	content, err := ot.Apply(r.content, external)
+	if err != nil {
+		return err
+	}
+
+	r.savedContent = current.Content
+	r.savedVersion = current.Version
+	r.amend = false
+	if external.IsNoop() {
+		r.unsaved = rebased
+		return nil
+	}
+
+	r.record(external, "", "", content)
+	r.unsaved = rebased
+	r.broadcast(nil, model.CollabMessage{Type: model.CollabOp, Revision: r.revision(), Op: external})
+	return nil
+}
+
+// end disconnects all clients and stops the timers of the session.
+func (r *collabRoom) end() {
+	r.closed = true
+	for _, client := range r.clients {
+		r.remove(client)
+	}
+	if r.saveTimer != nil {
+		r.saveTimer.Stop()
+		r.saveTimer = nil
+	}
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+}
+
+// collaborators returns the clients of the session with their cursors.
+func (r *collabRoom) collaborators() []model.Collaborator {
+	collaborators := make([]model.Collaborator, 0, len(r.clients))
+	for id, client := range r.clients {
+		collaborators = append(collaborators, model.Collaborator{
+			ClientID: id,
+			Name:     client.Name,
+			ReadOnly: client.ReadOnly,
+			Cursor:   r.cursors[id],
+		})
+	}
+	return collaborators
+}
+
+// reject tells a client that its message was not applied.
+func (r *collabRoom) reject(client *CollabClient, msg model.CollabMessage, reason string) {
+	r.send(client, model.CollabMessage{Type: model.CollabError, Revision: r.revision(), OpID: msg.OpID, Error: reason})
+}
+
+// broadcast sends a message to all clients but one, nil sends it to all of them.
+func (r *collabRoom) broadcast(except *CollabClient, msg model.CollabMessage) {
+	for _, client := range r.clients {
+		if client != except {
+			r.send(client, msg)
+		}
+	}
+}
+
+// send queues a message for a client, dropping the client if it fell too far behind.
+func (r *collabRoom) send(client *CollabClient, msg model.CollabMessage) {
+	select {
+	case client.send <- msg:
+	default:
+		r.remove(client)
+	}
+}
+
+// remove disconnects a client and tells the others it left. It returns false if
+// the client already left. Without clients, the session ends if no client joins
+// again in time.
+func (r *collabRoom) remove(client *CollabClient) bool {
+	if r.clients[client.ID] != client {
+		return false
+	}
+	delete(r.clients, client.ID)
+	delete(r.cursors, client.ID)
+	close(client.send)
+
+	r.broadcast(nil, model.CollabMessage{Type: model.CollabLeave, ClientID: client.ID, Revision: r.revision()})
+
+	if len(r.clients) == 0 && !r.closed && r.idleTimer == nil {
+		r.idleTimer = time.AfterFunc(r.svc.idleTimeout, func() {
+			r.svc.closeIdle(r)
+		})
+	}
+	return true
+}
+
+// clampIndex keeps a position within a text of the given length.
+func clampIndex(index, length int) int {
+	if index < 0 {
+		return 0
+	}
+	if index > length {
+		return length
+	}
+	return index
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"accuknox/repository"
+	"log"
+	"strings"
+	"time"
+)
+
+// LoginLimits configures how logins are protected against password guessing.
+type LoginLimits struct {
+	// DelayAfter is the number of failures after which every further login is
+	// delayed, by BaseDelay doubled with each failure.
+	DelayAfter int
+	BaseDelay  time.Duration
+	// MaxFailures is the number of failures locking the logins of an email for
+	// Lockout, MaxFailuresPerIP the same for the logins from an IP.
+	MaxFailures      int
+	MaxFailuresPerIP int
+	Lockout          time.Duration
+}
+
+// LoginGuard slows down and locks the logins of the emails and IPs that failed too often.
+type LoginGuard struct {
+	throttle repository.LoginThrottle
+	userRepo repository.UserRepository
+	limits   LoginLimits
+}
+
+// NewLoginGuard creates a new LoginGuard counting the failed logins with the
+// LoginThrottle and recording the lockouts with the UserRepository.
+func NewLoginGuard(throttle repository.LoginThrottle, userRepo repository.UserRepository, limits LoginLimits) *LoginGuard {
+	return &LoginGuard{throttle, userRepo, limits}
+}
+
+// LoginAttempt is a login reserved with LoginGuard.Attempt before its password or
+// code is checked. It counts as failed until it is found to succeed or released.
+type LoginAttempt struct {
+	guard *LoginGuard
+	email string
+	ip    string
+	// The failures of the email and the IP counting the attempt, 0 if the
+	// throttle could not count them
+	emailFailures int
+	ipFailures    int
+}
+
+// Attempt reserves a login of the email from the IP. Counting the login as failed
+// before the password is checked keeps concurrent logins from all getting past
+// the limits. It returns how long to wait instead if the login is refused.
+func (g *LoginGuard) Attempt(email, ip string) (*LoginAttempt, time.Duration) {
+	if wait := g.wait(email, ip); wait > 0 {
+		return nil, wait
+	}
+
+	attempt := &LoginAttempt{
+		guard:         g,
+		email:         email,
+		ip:            ip,
+		emailFailures: g.reserve(emailKey(email)),
+		ipFailures:    g.reserve(ipKey(ip)),
+	}
+	// Logins over the limit that raced past the wait are refused all the same
+	if attempt.emailFailures > g.limits.MaxFailures || attempt.ipFailures > g.limits.MaxFailuresPerIP {
+		attempt.Fail()
+		return nil, g.limits.Lockout
+	}
+	return attempt, 0
+}
+
+// Check runs check as a login of the email from the IP, for changes of an account
+// that take its password or a code. It returns a *myerrors.RetryError if the
+// logins are refused, and counts myerrors.ErrAuthentication as a failed login.
+func (g *LoginGuard) Check(email, ip string, check func() error) error {
+	attempt, wait := g.Attempt(email, ip)
+	if wait > 0 {
+		return &myerrors.RetryError{After: wait}
+	}
+
+	err := check()
+	switch err {
+	case nil:
+		attempt.Succeed()
+	case myerrors.ErrAuthentication:
+		attempt.Fail()
+	default:
+		attempt.Release()
+	}
+	return err
+}
+
+// Fail records that the login failed, delaying the next logins or locking them
+// once there were too many failures.
+func (a *LoginAttempt) Fail() {
+	g := a.guard
+	g.block(model.LockoutEmail, normalizeEmail(a.email), emailKey(a.email), a.emailFailures, g.limits.MaxFailures, a.ip)
+	g.block(model.LockoutIP, a.ip, ipKey(a.ip), a.ipFailures, g.limits.MaxFailuresPerIP, a.ip)
+}
+
+// Succeed records that the login succeeded, the failed logins of the email are forgotten.
+func (a *LoginAttempt) Succeed() {
+	a.guard.Clear(a.email)
+	a.guard.release(ipKey(a.ip), a.ipFailures)
+}
+
+// Release takes the attempt back without forgetting the earlier failures, for a
+// step of a login that did not fail but is not complete yet.
+func (a *LoginAttempt) Release() {
+	a.guard.release(emailKey(a.email), a.emailFailures)
+	a.guard.release(ipKey(a.ip), a.ipFailures)
+}
+
+// Clear forgets the failed logins of the email, after a successful login or password reset.
+func (g *LoginGuard) Clear(email string) {
+	if err := g.throttle.Reset(emailKey(email)); err != nil {
+		log.Println("[LoginGuard:Clear] ", err)
+	}
+}
+
+// wait returns how long a login of the email from the IP must wait, 0 if it may
+// be tried now. Errors of the throttle let logins through rather than lock everyone out.
+func (g *LoginGuard) wait(email, ip string) time.Duration {
+	var wait time.Duration
+	for _, key := range []string{emailKey(email), ipKey(ip)} {
+		left, err := g.throttle.BlockedFor(key)
+		if err != nil {
+			log.Println("[LoginGuard:Wait] ", err)
+			continue
+		}
+		if left > wait {
+			wait = left
+		}
+	}
+	return wait
+}
+
+// reserve counts a login of a key as failed, returning its failures or 0 if the
+// throttle could not count them.
+func (g *LoginGuard) reserve(key string) int {
+	failures, err := g.throttle.Fail(key)
+	if err != nil {
+		log.Println("[LoginGuard:Attempt] ", err)
+		return 0
+	}
+	return failures
+}
+
+// release takes back the failure reserved for a login of a key.
+func (g *LoginGuard) release(key string, failures int) {
+	if failures == 0 {
+		return
+	}
+	if err := g.throttle.Release(key); err != nil {
+		log.Println("[LoginGuard:Release] ", err)
+	}
+}
+
+// block blocks a key that failed for as long as its failures call for. The
+// lockout is recorded by the login reaching the limit.
+func (g *LoginGuard) block(scope, subject, key string, failures, maxFailures int, ip string) {
+	var block time.Duration
+	switch {
+	case failures == 0:
+		return
+	case failures >= maxFailures:
+		block = g.limits.Lockout
+	case failures >= g.limits.DelayAfter:
+		block = g.limits.BaseDelay
+		for i := g.limits.DelayAfter; i < failures && block < g.limits.Lockout; i++ {
+			block *= 2
+		}
+		if block > g.limits.Lockout {
+			block = g.limits.Lockout
+		}
+	default:
+		return
+	}
+
+	if err := g.throttle.Block(key, block); err != nil {
+		log.Println("[LoginGuard:Fail] ", err)
+		return
+	}
+	if failures != maxFailures {
+		return
+	}
+
+	lockout := &model.LoginLockout{
+		Scope:       scope,
+		Subject:     subject,
+		IP:          ip,
+		Failures:    failures,
+		LockedUntil: time.Now().Add(block),
+	}
+	log.Printf("[LoginGuard:Fail] locked the logins of %s %s after %d failures\n", scope, subject, failures)
+	if err := g.userRepo.RecordLockout(lockout); err != nil {
+		log.Println("[LoginGuard:Fail] ", err)
+	}
+}
+
+// emailKey returns the throttle key of the logins of an email.
+func emailKey(email string) string {
+	return "email:" + normalizeEmail(email)
+}
+
+// ipKey returns the throttle key of the logins from an IP.
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// normalizeEmail returns the form of an email failures are counted under,
+// so that changing its case does not get around the limits.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -2,91 +2,700 @@
 package service
 
 import (
+	"accuknox/diff"
+	"accuknox/mailer"
 	"accuknox/model"
 	"accuknox/myerrors"
 	"accuknox/repository"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Page sizes of note listings.
+const (
+	defaultNotesPageSize = 50
+	maxNotesPageSize     = 100
+)
+
+// Page sizes of sync pulls.
+const (
+	defaultSyncPageSize = 500
+	maxSyncPageSize     = 1000
+)
+
+// maxTagNameLength is the maximum length of a tag name in bytes.
+const maxTagNameLength = 50
+
 // NoteService provides methods for managing notes.
 type NoteService interface {
 	CreateNote(note *model.Note) (*model.Note, error)
-	GetNoteByID(id uint) (*model.Note, error)
-	GetAllNotesOfUser(userID uint) ([]*model.Note, error)
-	DeleteNote(userId, noteId uint) error
+	GetNoteByID(userID, noteID uint) (*model.Note, error)
+	GetAllNotesOfUser(userID uint, query model.NoteQuery) (*model.NotePage, error)
+	SearchNotes(userID uint, text string, limit, offset int) ([]*model.NoteSearchResult, error)
+	UpdateNote(userID, noteID uint, update model.NoteUpdate) (*model.Note, error)
+	DeleteNote(userId, noteId, version uint) error
+	GetTrashedNotesOfUser(userID uint) ([]*model.Note, error)
+	RestoreNote(userID, noteID uint) (*model.Note, error)
+	EmptyTrash(userID uint) (int64, error)
+	PurgeTrashedNotes(before time.Time) (int64, error)
+	PurgeTombstones(before time.Time) (int64, error)
+	GetRevisions(userID, noteID uint) ([]*model.NoteRevision, error)
+	GetRevision(userID, noteID, revision uint) (*model.NoteRevision, error)
+	DiffRevisions(userID, noteID, from, to uint) (string, error)
+	RestoreRevision(userID, noteID, revision uint) (*model.Note, error)
+	GetNoteChanges(userID uint, cursor string, limit int) (*model.SyncPage, error)
+	PushNoteChanges(userID uint, items []model.SyncItem) []*model.SyncResult
 	// Add more note-related methods here
 }
 
+// TagService provides methods for managing the tags of notes.
+type TagService interface {
+	GetTagsOfUser(userID uint) ([]*model.TagUsage, error)
+	RenameTag(userID, tagID uint, name string) (*model.Tag, error)
+	DeleteTag(userID, tagID uint) error
+}
+
+// NotebookService provides methods for managing notebooks.
+type NotebookService interface {
+	CreateNotebook(notebook *model.Notebook) (*model.Notebook, error)
+	GetNotebooksOfUser(userID uint) ([]*model.Notebook, error)
+	GetNotebookByID(userID, notebookID uint) (*model.Notebook, error)
+	UpdateNotebook(userID, notebookID uint, update model.NotebookUpdate) (*model.Notebook, error)
+	DeleteNotebook(userID, notebookID uint, cascade bool) error
+}
+
+// ShareService provides methods for sharing notes with other users.
+type ShareService interface {
+	ShareNote(ownerID, noteID uint, email, permission string) (*model.NoteShare, error)
+	GetSharesOfNote(ownerID, noteID uint) ([]*model.NoteShare, error)
+	RevokeShare(ownerID, noteID, shareID uint) error
+	GetNotesSharedWithUser(userID uint) ([]*model.SharedNote, error)
+}
+
+// PublicLinkService provides methods for reading notes through public links.
+type PublicLinkService interface {
+	CreateLink(ownerID, noteID uint, expiresAt *time.Time, password string) (*model.PublicLink, string, error)
+	GetLinksOfNote(ownerID, noteID uint) ([]*model.PublicLink, error)
+	RevokeLink(ownerID, noteID, linkID uint) error
+	ViewNote(token, password string, client model.ClientInfo) (*model.Note, error)
+}
+
+// EventService provides methods for following the changes of notes.
+type EventService interface {
+	// Subscribe returns the channel receiving the note events of the user and, if
+	// lastEventID is not 0, the events the user missed since that event.
+	Subscribe(userID uint, lastEventID uint64) (<-chan *model.NoteEvent, []*model.NoteEvent, func(), error)
+}
+
 // UserService provides methods for user management.
 type UserService interface {
-	CreateUser(name, email, password string) (string, error)
-	Login(email, password string) (string, error) // Add the Login method
+	CreateUser(name, email, password string, client model.ClientInfo) (string, error)
+	Login(email, password string, client model.ClientInfo) (*model.LoginResult, error) // Add the Login method
+	GetUser(userID uint) (*model.User, error)
+	RequestPasswordReset(email string) error
+	ResetPassword(token, password string) error
+	VerifyEmail(token string) error
+	ResendVerificationEmail(userID uint) error
+	UpdateProfile(userID uint, update model.UserUpdate, password string, client model.ClientInfo) (*model.User, error)
+	ChangePassword(userID uint, sid, currentPassword, newPassword string, client model.ClientInfo) error
+	DeleteAccount(userID uint, password, code string, client model.ClientInfo) error
 	// Add more user-related methods here
 }
 
 // SessionService defines methods for session management.
 type SessionService interface {
-	IsValidSession(sid string) (uint, bool)
+	ValidateSession(sid string) (uint, error)
+	TouchSession(sid string) error
+	GetSessionsOfUser(userID uint) ([]*model.UserSession, error)
+	RevokeSession(sid string) error
+	RevokeSessionByID(userID, sessionID uint) error
+	RevokeAllSessions(userID uint) error
 }
 
 type noteService struct {
-	noteRepo repository.NoteRepository
+	noteRepo  repository.NoteRepository
+	shareRepo repository.ShareRepository
+	events    repository.EventBus
+	// tombstoneRetention is how long permanent deletions are kept for syncing clients
+	tombstoneRetention time.Duration
+}
+
+type eventService struct {
+	events repository.EventBus
+}
+
+type tagService struct {
+	tagRepo   repository.TagRepository
+	shareRepo repository.ShareRepository
+	events    repository.EventBus
+}
+
+type notebookService struct {
+	notebookRepo repository.NotebookRepository
+	shareRepo    repository.ShareRepository
+	events       repository.EventBus
+}
+
+type shareService struct {
+	shareRepo repository.ShareRepository
+	noteRepo  repository.NoteRepository
+	userRepo  repository.UserRepository
+}
+
+type publicLinkService struct {
+	linkRepo  repository.PublicLinkRepository
+	noteRepo  repository.NoteRepository
+	shareRepo repository.ShareRepository
+	// throttle counts the wrong passwords of a link per client, past maxFailures
+	// the client is refused for lockout
+	throttle    repository.LoginThrottle
+	maxFailures int
+	lockout     time.Duration
 }
 
 // userService struct
 type userService struct {
-	userRepo repository.UserRepository
+	userRepo  repository.UserRepository
+	twoFactor TwoFactorService
+	guard     *LoginGuard
+	mailer    mailer.Mailer
+	appURL    string
+	resetTTL  time.Duration
+	verifyTTL time.Duration
 }
 
 // SessionServiceImpl implements SessionService.
 type SessionServiceImpl struct {
-	userRepo repository.UserRepository
+	sessions repository.SessionStore
 }
 
-// NewNoteService creates a new NoteService with the provided NoteRepository.
-func NewNoteService(noteRepo repository.NoteRepository) NoteService {
-	return &noteService{noteRepo}
+// NewNoteService creates a new NoteService with the provided NoteRepository,
+// checking the access to shared notes with the ShareRepository and publishing
+// the changes of notes on the EventBus.
+func NewNoteService(noteRepo repository.NoteRepository, shareRepo repository.ShareRepository, events repository.EventBus, tombstoneRetention time.Duration) NoteService {
+	return &noteService{noteRepo, shareRepo, events, tombstoneRetention}
 }
 
-// NewUserService creates a new UserService with the provided UserRepository.
-func NewUserService(userRepo repository.UserRepository) UserService {
-	return &userService{userRepo}
+// NewEventService creates a new EventService with the provided EventBus.
+func NewEventService(events repository.EventBus) EventService {
+	return &eventService{events}
 }
 
-// NewSessionService creates a new SessionService with the provided UserRepository.
-func NewSessionService(userRepo repository.UserRepository) SessionService {
-	return &SessionServiceImpl{userRepo}
+// NewTagService creates a new TagService with the provided TagRepository,
+// publishing the changes of the notes using a tag on the EventBus.
+func NewTagService(tagRepo repository.TagRepository, shareRepo repository.ShareRepository, events repository.EventBus) TagService {
+	return &tagService{tagRepo, shareRepo, events}
+}
+
+// NewNotebookService creates a new NotebookService with the provided NotebookRepository,
+// publishing the changes of the notes of deleted notebooks on the EventBus.
+func NewNotebookService(notebookRepo repository.NotebookRepository, shareRepo repository.ShareRepository, events repository.EventBus) NotebookService {
+	return &notebookService{notebookRepo, shareRepo, events}
+}
+
+// NewShareService creates a new ShareService with the provided repositories.
+func NewShareService(shareRepo repository.ShareRepository, noteRepo repository.NoteRepository, userRepo repository.UserRepository) ShareService {
+	return &shareService{shareRepo, noteRepo, userRepo}
+}
+
+// NewPublicLinkService creates a new PublicLinkService with the provided repositories.
+// Wrong passwords of a link are counted with the LoginThrottle, a client giving
+// maxFailures of them is refused for lockout.
+func NewPublicLinkService(linkRepo repository.PublicLinkRepository, noteRepo repository.NoteRepository, shareRepo repository.ShareRepository,
+	throttle repository.LoginThrottle, maxFailures int, lockout time.Duration) PublicLinkService {
+	return &publicLinkService{linkRepo, noteRepo, shareRepo, throttle, maxFailures, lockout}
+}
+
+// NewUserService creates a new UserService with the provided UserRepository, asking
+// for a code of the TwoFactorService at login when the user enabled it, limiting
+// password guesses with the LoginGuard and sending emails with links to appURL
+// through the Mailer. Password reset links expire after resetTTL and email
+// verification links after verifyTTL.
+func NewUserService(userRepo repository.UserRepository, twoFactor TwoFactorService, guard *LoginGuard, mail mailer.Mailer,
+	appURL string, resetTTL, verifyTTL time.Duration) UserService {
+	return &userService{userRepo, twoFactor, guard, mail, strings.TrimSuffix(appURL, "/"), resetTTL, verifyTTL}
+}
+
+// NewSessionService creates a new SessionService with the provided SessionStore.
+func NewSessionService(sessions repository.SessionStore) SessionService {
+	return &SessionServiceImpl{sessions}
 }
 
 // CreateNote creates a new note.
 func (s *noteService) CreateNote(note *model.Note) (*model.Note, error) {
-	// Implement the CreateNote method using the noteRepo
-	return s.noteRepo.CreateNote(note)
+	names := make([]string, 0, len(note.Tags))
+	for _, tag := range note.Tags {
+		names = append(names, tag.Name)
+	}
+	names, err := normalizeTagNames(names)
+	if err != nil {
+		return nil, err
+	}
+
+	note.Tags = note.Tags[:0]
+	for _, name := range names {
+		note.Tags = append(note.Tags, model.Tag{Name: name})
+	}
+
+	note, err = s.noteRepo.CreateNote(note)
+	if err != nil {
+		return nil, err
+	}
+	s.publishEvent(model.EventNoteCreated, note)
+	return note, nil
 }
 
-// GetNoteByID retrieves a note by its ID.
-func (s *noteService) GetNoteByID(id uint) (*model.Note, error) {
-	// Implement the GetNoteByID method using the noteRepo
-	return s.noteRepo.GetNoteByID(id)
+// GetNoteByID retrieves a note the user owns or that was shared with them by its ID.
+func (s *noteService) GetNoteByID(userID, noteID uint) (*model.Note, error) {
+	return authorizeNote(s.noteRepo, s.shareRepo, userID, noteID, model.PermissionRead)
 }
 
-// GetAllNotesOfUser retrieves all notes of a user by their UserID.
-func (s *noteService) GetAllNotesOfUser(userID uint) ([]*model.Note, error) {
-	// Implement the GetAllNotesOfUser method using the noteRepo
-	return s.noteRepo.GetAllNotesOfUser(userID)
+// GetAllNotesOfUser retrieves a page of notes of a user by their UserID.
+func (s *noteService) GetAllNotesOfUser(userID uint, query model.NoteQuery) (*model.NotePage, error) {
+	// Apply the defaults for the options that were not provided
+	if query.Limit <= 0 {
+		query.Limit = defaultNotesPageSize
+	}
+	if query.Limit > maxNotesPageSize {
+		query.Limit = maxNotesPageSize
+	}
+	if query.SortBy == "" {
+		query.SortBy = model.NoteSortCreatedAt
+	}
+	if query.Order == "" {
+		query.Order = model.SortDesc
+	}
+
+	tags, err := normalizeTagNames(query.Tags)
+	if err != nil {
+		return nil, err
+	}
+	query.Tags = tags
+
+	return s.noteRepo.GetAllNotesOfUser(userID, query)
+}
+
+// SearchNotes retrieves the notes of the user matching the search text.
+func (s *noteService) SearchNotes(userID uint, text string, limit, offset int) ([]*model.NoteSearchResult, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, myerrors.ErrInvalidInput
+	}
+	if limit <= 0 {
+		limit = defaultNotesPageSize
+	}
+	if limit > maxNotesPageSize {
+		limit = maxNotesPageSize
+	}
+
+	return s.noteRepo.SearchNotes(userID, text, limit, offset)
+}
+
+// UpdateNote applies a partial update to a note the user may edit. Only the
+// owner may change the tags and the notebook of the note.
+func (s *noteService) UpdateNote(userID, noteID uint, update model.NoteUpdate) (*model.Note, error) {
+	note, err := authorizeNote(s.noteRepo, s.shareRepo, userID, noteID, model.PermissionEdit)
+	if err != nil {
+		return nil, err
+	}
+	if note.UserID != userID && (update.Tags != nil || update.NotebookID != nil) {
+		return nil, myerrors.ErrUnauthorized
+	}
+
+	if update.Tags != nil {
+		tags, err := normalizeTagNames(*update.Tags)
+		if err != nil {
+			return nil, err
+		}
+		update.Tags = &tags
+	}
+
+	updated, err := s.noteRepo.UpdateNote(note.UserID, noteID, update)
+	if err != nil {
+		return nil, err
+	}
+	if updated.Version != note.Version {
+		s.publishEvent(model.EventNoteUpdated, updated)
+	}
+	return updated, nil
+}
+
+// DeleteNote moves a note to the trash by its ID, if it is still at the given version.
+// Only the owner of a note may delete it.
+func (s *noteService) DeleteNote(userId, noteId, version uint) error {
+	note, err := authorizeNote(s.noteRepo, s.shareRepo, userId, noteId, model.PermissionOwner)
+	if err != nil {
+		return err
+	}
+	if err := s.noteRepo.DeleteNote(userId, noteId, version); err != nil {
+		return err
+	}
+	s.publishEvent(model.EventNoteDeleted, note)
+	return nil
 }
 
-// DeleteNote deletes a note by its ID.
-func (s *noteService) DeleteNote(userId, noteId uint) error {
-	// Implement the DeleteNote method using the noteRepo
-	return s.noteRepo.DeleteNote(userId, noteId)
+// GetTrashedNotesOfUser retrieves the notes of a user that are in the trash.
+func (s *noteService) GetTrashedNotesOfUser(userID uint) ([]*model.Note, error) {
+	return s.noteRepo.GetTrashedNotesOfUser(userID)
+}
+
+// RestoreNote moves a note of the user out of the trash.
+func (s *noteService) RestoreNote(userID, noteID uint) (*model.Note, error) {
+	note, err := s.noteRepo.RestoreNote(userID, noteID)
+	if err != nil {
+		return nil, err
+	}
+	// The note reappears for the clients that dropped it when it was deleted
+	s.publishEvent(model.EventNoteCreated, note)
+	return note, nil
+}
+
+// EmptyTrash permanently deletes all notes of the user that are in the trash.
+func (s *noteService) EmptyTrash(userID uint) (int64, error) {
+	return s.noteRepo.EmptyTrash(userID)
+}
+
+// PurgeTrashedNotes permanently deletes the notes moved to the trash before the given time.
+func (s *noteService) PurgeTrashedNotes(before time.Time) (int64, error) {
+	return s.noteRepo.PurgeTrashedNotes(before)
+}
+
+// PurgeTombstones forgets the notes permanently deleted before the given time,
+// clients with an older cursor then sync all over again.
+func (s *noteService) PurgeTombstones(before time.Time) (int64, error) {
+	return s.noteRepo.PurgeTombstones(before)
+}
+
+// GetRevisions retrieves the revisions of a note the user may read, latest first.
+func (s *noteService) GetRevisions(userID, noteID uint) ([]*model.NoteRevision, error) {
+	note, err := authorizeNote(s.noteRepo, s.shareRepo, userID, noteID, model.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	return s.noteRepo.GetRevisions(note.UserID, noteID)
+}
+
+// GetRevision retrieves a revision of a note the user may read.
+func (s *noteService) GetRevision(userID, noteID, revision uint) (*model.NoteRevision, error) {
+	note, err := authorizeNote(s.noteRepo, s.shareRepo, userID, noteID, model.PermissionRead)
+	if err != nil {
+		return nil, err
+	}
+	return s.noteRepo.GetRevision(note.UserID, noteID, revision)
+}
+
+// DiffRevisions returns the unified diff of a note between two revisions.
+// The title is compared as a heading line above the content.
+func (s *noteService) DiffRevisions(userID, noteID, from, to uint) (string, error) {
+	note, err := authorizeNote(s.noteRepo, s.shareRepo, userID, noteID, model.PermissionRead)
+	if err != nil {
+		return "", err
+	}
+
+	fromRev, err := s.noteRepo.GetRevision(note.UserID, noteID, from)
+	if err != nil {
+		return "", err
+	}
+	toRev, err := s.noteRepo.GetRevision(note.UserID, noteID, to)
+	if err != nil {
+		return "", err
+	}
+
+	return diff.Unified(
+		fmt.Sprintf("revision %d", from),
+		fmt.Sprintf("revision %d", to),
+		revisionText(fromRev),
+		revisionText(toRev),
+	), nil
+}
+
+// RestoreRevision rolls a note the user may edit back to a revision.
+func (s *noteService) RestoreRevision(userID, noteID, revision uint) (*model.Note, error) {
+	note, err := authorizeNote(s.noteRepo, s.shareRepo, userID, noteID, model.PermissionEdit)
+	if err != nil {
+		return nil, err
+	}
+
+	note, err = s.noteRepo.RestoreRevision(note.UserID, noteID, revision)
+	if err != nil {
+		return nil, err
+	}
+	s.publishEvent(model.EventNoteUpdated, note)
+	return note, nil
+}
+
+// GetNoteChanges retrieves the changes of the notes of the user since the cursor
+// of their last sync, or all their notes without a cursor. Cursors older than
+// the retention of the tombstones get all the notes again in a reset page.
+func (s *noteService) GetNoteChanges(userID uint, cursor string, limit int) (*model.SyncPage, error) {
+	if limit <= 0 {
+		limit = defaultSyncPageSize
+	}
+	if limit > maxSyncPageSize {
+		limit = maxSyncPageSize
+	}
+
+	return s.noteRepo.GetNoteChanges(userID, cursor, limit, time.Now().Add(-s.tombstoneRetention))
+}
+
+// PushNoteChanges applies the changes a client made while offline, in order.
+// Every item is applied on its own: updates and deletes of notes that changed since
+// the version the client saw are reported as conflicts with the current note, for
+// the client to merge and push again.
+func (s *noteService) PushNoteChanges(userID uint, items []model.SyncItem) []*model.SyncResult {
+	results := make([]*model.SyncResult, 0, len(items))
+	for i, item := range items {
+		result := &model.SyncResult{Index: i, NoteID: item.NoteID, Status: model.SyncAccepted}
+		note, err := s.applySyncItem(userID, item)
+		switch {
+		case err == nil:
+			result.Note = note
+			if note != nil {
+				result.NoteID = note.ID
+			}
+		case err == myerrors.ErrStaleVersion:
+			result.Status = model.SyncConflict
+			result.Note, err = s.GetNoteByID(userID, item.NoteID)
+			if err != nil {
+				// The note is gone since, the next pull reports its deletion
+				result.Status = model.SyncRejected
+				result.Error = syncError(err)
+			}
+		default:
+			result.Status = model.SyncRejected
+			result.Error = syncError(err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// applySyncItem applies a change pushed by a client, returning the resulting note
+// unless it was deleted.
+func (s *noteService) applySyncItem(userID uint, item model.SyncItem) (*model.Note, error) {
+	if item.Action != model.SyncCreate && (item.NoteID == 0 || item.Version == 0) {
+		return nil, myerrors.ErrInvalidInput
+	}
+
+	switch item.Action {
+	case model.SyncCreate:
+		note := &model.Note{UserID: userID}
+		if item.Title != nil {
+			note.Title = *item.Title
+		}
+		if item.Content != nil {
+			note.Content = *item.Content
+		}
+		if item.Tags != nil {
+			for _, name := range *item.Tags {
+				note.Tags = append(note.Tags, model.Tag{Name: name})
+			}
+		}
+		if item.NotebookID != nil && *item.NotebookID != 0 {
+			note.NotebookID = item.NotebookID
+		}
+		return s.CreateNote(note)
+	case model.SyncUpdate:
+		return s.UpdateNote(userID, item.NoteID, model.NoteUpdate{
+			Title:      item.Title,
+			Content:    item.Content,
+			Tags:       item.Tags,
+			NotebookID: item.NotebookID,
+			Version:    item.Version,
+		})
+	case model.SyncDelete:
+		return nil, s.DeleteNote(userID, item.NoteID, item.Version)
+	default:
+		return nil, myerrors.ErrInvalidInput
+	}
+}
+
+// syncError describes why a pushed change was rejected.
+func syncError(err error) string {
+	switch err {
+	case myerrors.ErrInvalidInput:
+		return "Invalid change"
+	case myerrors.ErrRecordNotFound:
+		return "Note not found"
+	case myerrors.ErrUnauthorized:
+		return "Not allowed to change the note"
+	case myerrors.ErrNotebookNotFound:
+		return "Notebook not found"
+	default:
+		return "Failed to apply change"
+	}
+}
+
+// publishEvent publishes an event about a change of a note.
+func (s *noteService) publishEvent(eventType string, note *model.Note) {
+	publishNoteEvent(s.shareRepo, s.events, eventType, note)
+}
+
+// Subscribe returns the channel receiving the note events of the user and the events
+// they missed since lastEventID. It subscribes before looking the missed events up
+// so that no event falls in between, the caller skips the events it already sent.
+func (s *eventService) Subscribe(userID uint, lastEventID uint64) (<-chan *model.NoteEvent, []*model.NoteEvent, func(), error) {
+	events, cancel := s.events.Subscribe(userID)
+	if lastEventID == 0 {
+		return events, nil, cancel, nil
+	}
+
+	missed, err := s.events.Since(userID, lastEventID)
+	if err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+	return events, missed, cancel, nil
+}
+
+// ShareNote grants the user with the given email access to a note of the owner.
+// Sharing the note again with the same user replaces the permission.
+func (s *shareService) ShareNote(ownerID, noteID uint, email, permission string) (*model.NoteShare, error) {
+	if permission != model.PermissionRead && permission != model.PermissionEdit {
+		return nil, myerrors.ErrInvalidInput
+	}
+	if _, err := authorizeNote(s.noteRepo, s.shareRepo, ownerID, noteID, model.PermissionOwner); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByEmail(strings.TrimSpace(email))
+	if err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			return nil, myerrors.ErrUserNotFound
+		}
+		return nil, err
+	}
+	if user.ID == ownerID {
+		return nil, myerrors.ErrInvalidInput
+	}
+
+	share, err := s.shareRepo.CreateShare(&model.NoteShare{NoteID: noteID, UserID: user.ID, Permission: permission})
+	if err != nil {
+		return nil, err
+	}
+	share.User = *user
+	return share, nil
+}
+
+// GetSharesOfNote retrieves the shares of a note of the owner.
+func (s *shareService) GetSharesOfNote(ownerID, noteID uint) ([]*model.NoteShare, error) {
+	if _, err := authorizeNote(s.noteRepo, s.shareRepo, ownerID, noteID, model.PermissionOwner); err != nil {
+		return nil, err
+	}
+	return s.shareRepo.GetSharesOfNote(noteID)
+}
+
+// RevokeShare revokes a share of a note of the owner.
+func (s *shareService) RevokeShare(ownerID, noteID, shareID uint) error {
+	if _, err := authorizeNote(s.noteRepo, s.shareRepo, ownerID, noteID, model.PermissionOwner); err != nil {
+		return err
+	}
+	return s.shareRepo.DeleteShare(noteID, shareID)
+}
+
+// GetNotesSharedWithUser retrieves the notes other users shared with the user.
+func (s *shareService) GetNotesSharedWithUser(userID uint) ([]*model.SharedNote, error) {
+	return s.shareRepo.GetNotesSharedWithUser(userID)
+}
+
+// GetTagsOfUser retrieves all tags of a user with their usage counts.
+func (s *tagService) GetTagsOfUser(userID uint) ([]*model.TagUsage, error) {
+	return s.tagRepo.GetTagsOfUser(userID)
+}
+
+// RenameTag renames a tag of the user across all of their notes.
+func (s *tagService) RenameTag(userID, tagID uint, name string) (*model.Tag, error) {
+	names, err := normalizeTagNames([]string{name})
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, myerrors.ErrInvalidInput
+	}
+
+	tag, notes, err := s.tagRepo.RenameTag(userID, tagID, names[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, note := range notes {
+		publishNoteEvent(s.shareRepo, s.events, model.EventNoteUpdated, note)
+	}
+	return tag, nil
+}
+
+// DeleteTag deletes a tag of the user from all of their notes.
+func (s *tagService) DeleteTag(userID, tagID uint) error {
+	notes, err := s.tagRepo.DeleteTag(userID, tagID)
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		publishNoteEvent(s.shareRepo, s.events, model.EventNoteUpdated, note)
+	}
+	return nil
+}
+
+// CreateNotebook creates a new notebook.
+func (s *notebookService) CreateNotebook(notebook *model.Notebook) (*model.Notebook, error) {
+	notebook.Name = strings.TrimSpace(notebook.Name)
+	if notebook.Name == "" {
+		return nil, myerrors.ErrInvalidInput
+	}
+	return s.notebookRepo.CreateNotebook(notebook)
+}
+
+// GetNotebooksOfUser retrieves all notebooks of a user.
+func (s *notebookService) GetNotebooksOfUser(userID uint) ([]*model.Notebook, error) {
+	return s.notebookRepo.GetNotebooksOfUser(userID)
+}
+
+// GetNotebookByID retrieves a notebook of the user by its ID.
+func (s *notebookService) GetNotebookByID(userID, notebookID uint) (*model.Notebook, error) {
+	return s.notebookRepo.GetNotebookByID(userID, notebookID)
+}
+
+// UpdateNotebook renames and/or moves a notebook of the user.
+func (s *notebookService) UpdateNotebook(userID, notebookID uint, update model.NotebookUpdate) (*model.Notebook, error) {
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if name == "" {
+			return nil, myerrors.ErrInvalidInput
+		}
+		update.Name = &name
+	}
+	return s.notebookRepo.UpdateNotebook(userID, notebookID, update)
+}
+
+// DeleteNotebook deletes a notebook of the user, either with its content or
+// handing the content over to its parent.
+func (s *notebookService) DeleteNotebook(userID, notebookID uint, cascade bool) error {
+	notes, err := s.notebookRepo.DeleteNotebook(userID, notebookID, cascade)
+	if err != nil {
+		return err
+	}
+
+	eventType := model.EventNoteUpdated
+	if cascade {
+		eventType = model.EventNoteDeleted
+	}
+	for _, note := range notes {
+		publishNoteEvent(s.shareRepo, s.events, eventType, note)
+	}
+	return nil
 }
 
 // ...
 
 // CreateUser creates a new user and returns the created user object.
-func (s *userService) CreateUser(name, email, password string) (string, error) {
+func (s *userService) CreateUser(name, email, password string, client model.ClientInfo) (string, error) {
 	// Generate a password hash for the provided password
 	hashedPassword, err := generatePasswordHash(password)
 	if err != nil {
@@ -100,9 +709,17 @@ func (s *userService) CreateUser(name, email, password string) (string, error) {
 	}
 
 	// Call the repository method to create the user
-	s.userRepo.CreateUser(&user)
+	if _, err := s.userRepo.CreateUser(&user); err != nil {
+		return "", err
+	}
+
+	// The account works right away, a failed email can be sent again later
+	if err := s.sendVerificationEmail(&user); err != nil {
+		log.Println("[Service:CreateUser] ", err)
+	}
+
 	//On success, create new uinque session
-	newSession := &model.UserSession{UserID: user.ID}
+	newSession := &model.UserSession{UserID: user.ID, IP: client.IP, UserAgent: client.UserAgent}
 	newSession, err = s.userRepo.CreateSession(newSession)
 	if err != nil {
 		return "", err
@@ -110,34 +727,581 @@ func (s *userService) CreateUser(name, email, password string) (string, error) {
 	return newSession.SID, nil
 }
 
-// Login authenticates a user with their email and password.
-func (s *userService) Login(email, password string) (string, error) {
+// Login authenticates a user with their email and password. Users who enabled
+// two-factor authentication get a challenge to complete with a code instead of a session.
+// Emails and IPs that failed too often are refused with myerrors.ErrTooManyAttempts
+// and a result telling how long to wait.
+func (s *userService) Login(email, password string, client model.ClientInfo) (*model.LoginResult, error) {
+	attempt, wait := s.guard.Attempt(email, client.IP)
+	if wait > 0 {
+		return &model.LoginResult{RetryAfter: wait}, myerrors.ErrTooManyAttempts
+	}
+
 	// Implement the Login method using the userRepo
 	user, err := s.userRepo.GetUserByEmail(email)
-	if err != nil {
-		return "", err
+	if err != nil && err != myerrors.ErrRecordNotFound {
+		attempt.Release()
+		return nil, err
 	}
 
-	// Check if the provided password matches the user's stored password
+	// Check if the provided password matches the user's stored password, unknown
+	// emails fail the same way and take as long so that they cannot be told apart
+	if user == nil {
+		checkPasswordHash(password, dummyPasswordHash())
+		attempt.Fail()
+		return nil, myerrors.ErrAuthentication
+	}
 	if !checkPasswordHash(password, user.PasswordHash) {
-		return "", myerrors.ErrAuthentication // Custom authentication error
+		attempt.Fail()
+		return nil, myerrors.ErrAuthentication // Custom authentication error
+	}
+
+	// The failures are only forgotten once the code is entered too
+	if user.TwoFactorEnabled {
+		attempt.Release()
+		challenge, err := s.twoFactor.CreateChallenge(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &model.LoginResult{Challenge: challenge}, nil
 	}
 
 	//On success, create new uinque session
-	newSession := &model.UserSession{UserID: user.ID}
+	newSession := &model.UserSession{UserID: user.ID, IP: client.IP, UserAgent: client.UserAgent}
 	newSession, err = s.userRepo.CreateSession(newSession)
+	if err != nil {
+		attempt.Release()
+		return nil, err
+	}
+	attempt.Succeed()
+
+	return &model.LoginResult{SID: newSession.SID}, nil
+}
+
+// RequestPasswordReset emails a password reset link to the user with the given
+// email. Unknown emails are ignored so that callers cannot tell which accounts exist.
+func (s *userService) RequestPasswordReset(email string) error {
+	user, err := s.userRepo.GetUserByEmail(email)
+	if err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+
+	token, err := issueUserToken(s.userRepo, user.ID, model.TokenPasswordReset, s.resetTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nUse this link to choose a new password, it expires in %s:\n\n%s/reset-password?token=%s\n\n"+
+		"If you did not ask to reset your password, you can ignore this email.",
+		user.Name, formatTTL(s.resetTTL), s.appURL, token)
+	if err := s.mailer.Send(user.Email, "Reset your password", body); err != nil {
+		log.Println("[Service:RequestPasswordReset] ", err)
+		return myerrors.ErrInternalServer
+	}
+	return nil
+}
+
+// ResetPassword sets a new password with a password reset token, lifts the lock
+// of the logins of the user and ends every session of the user, which may have
+// been opened by whoever knew the old password.
+func (s *userService) ResetPassword(token, password string) error {
+	hashedPassword, err := generatePasswordHash(password)
+	if err != nil {
+		return err
+	}
+
+	userID, err := s.userRepo.ResetPassword(hashToken(token), hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	// The owner of the email proved who they are, lift the lock of their logins
+	if user, err := s.userRepo.GetUserByID(userID); err == nil {
+		s.guard.Clear(user.Email)
+	}
+	return s.userRepo.RevokeAllSessions(userID)
+}
+
+// GetUser retrieves a user by their ID.
+func (s *userService) GetUser(userID uint) (*model.User, error) {
+	return s.userRepo.GetUserByID(userID)
+}
+
+// VerifyEmail marks the email of a user as verified with the token of a verification
+// email, or switches the user to their pending email with the token sent to it.
+func (s *userService) VerifyEmail(token string) error {
+	tokenHash := hashToken(token)
+	_, err := s.userRepo.VerifyEmail(tokenHash)
+	if err == myerrors.ErrRecordNotFound {
+		// Both emails link to the same page
+		_, err = s.userRepo.ChangeEmail(tokenHash)
+	}
+	return err
+}
+
+// ResendVerificationEmail sends a new verification email to the user, the links
+// of the previous ones stop working. It returns myerrors.ErrConflict if the email
+// is already verified.
+func (s *userService) ResendVerificationEmail(userID uint) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return myerrors.ErrConflict
+	}
+	return s.sendVerificationEmail(user)
+}
+
+// UpdateProfile changes the name and/or email of the user. Changing the email
+// takes the password of the user, a wrong one counts as a failed login from the
+// client. The new email only replaces the current one once the link sent to it is followed.
+func (s *userService) UpdateProfile(userID uint, update model.UserUpdate, password string, client model.ClientInfo) (*model.User, error) {
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if name == "" {
+			return nil, myerrors.ErrInvalidInput
+		}
+		update.Name = &name
+	}
+	if update.Email != nil {
+		email := strings.TrimSpace(*update.Email)
+		update.Email = &email
+	}
+
+	before, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	changeEmail := update.Email != nil && *update.Email != before.Email
+	if changeEmail {
+		err := s.guard.Check(before.Email, client.IP, func() error {
+			if !checkPasswordHash(password, before.PasswordHash) {
+				return myerrors.ErrAuthentication
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := s.userRepo.UpdateUser(userID, update)
+	if err != nil {
+		return nil, err
+	}
+
+	// The profile is saved either way, asking for the email again sends a new link
+	if changeEmail {
+		if err := s.sendEmailChangeEmail(user); err != nil {
+			log.Println("[Service:UpdateProfile] ", err)
+		}
+	}
+	return user, nil
+}
+
+// ChangePassword replaces the password of the user given their current one and
+// signs out their other sessions, keeping the session with the given SID. A wrong
+// password counts as a failed login of the user from the client.
+func (s *userService) ChangePassword(userID uint, sid, currentPassword, newPassword string, client model.ClientInfo) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	err = s.guard.Check(user.Email, client.IP, func() error {
+		if !checkPasswordHash(currentPassword, user.PasswordHash) {
+			return myerrors.ErrAuthentication
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := generatePasswordHash(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdatePassword(userID, hashedPassword); err != nil {
+		return err
+	}
+	return s.userRepo.RevokeOtherSessions(userID, sid)
+}
+
+// DeleteAccount permanently deletes the user with their notes, shares and
+// sessions. It takes the password of the user, and a code as well if they
+// enabled two-factor authentication. Wrong ones count as failed logins of the
+// user from the client.
+func (s *userService) DeleteAccount(userID uint, password, code string, client model.ClientInfo) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	err = s.guard.Check(user.Email, client.IP, func() error {
+		if !checkPasswordHash(password, user.PasswordHash) {
+			return myerrors.ErrAuthentication
+		}
+		if user.TwoFactorEnabled {
+			return s.twoFactor.Verify(user, code)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.userRepo.DeleteUser(userID); err != nil {
+		return err
+	}
+	return s.userRepo.RevokeAllSessions(userID)
+}
+
+// sendVerificationEmail emails a link verifying the email of the user.
+func (s *userService) sendVerificationEmail(user *model.User) error {
+	token, err := issueUserToken(s.userRepo, user.ID, model.TokenEmailVerification, s.verifyTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nPlease confirm your email with this link, it expires in %s:\n\n%s/verify-email?token=%s\n\n"+
+		"If you did not create an account, you can ignore this email.",
+		user.Name, formatTTL(s.verifyTTL), s.appURL, token)
+	if err := s.mailer.Send(user.Email, "Verify your email", body); err != nil {
+		log.Println("[Service:sendVerificationEmail] ", err)
+		return myerrors.ErrInternalServer
+	}
+	return nil
+}
+
+// sendEmailChangeEmail emails a link confirming the pending email of the user to
+// that email, and tells the current email about the change.
+func (s *userService) sendEmailChangeEmail(user *model.User) error {
+	token, err := issueUserToken(s.userRepo, user.ID, model.TokenEmailChange, s.verifyTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nPlease confirm your new email with this link, it expires in %s:\n\n%s/verify-email?token=%s\n\n"+
+		"If you did not ask for this change, you can ignore this email.",
+		user.Name, formatTTL(s.verifyTTL), s.appURL, token)
+	if err := s.mailer.Send(user.PendingEmail, "Confirm your new email", body); err != nil {
+		log.Println("[Service:sendEmailChangeEmail] ", err)
+		return myerrors.ErrInternalServer
+	}
+
+	notice := fmt.Sprintf("Hi %s,\n\nThe email of your account is being changed to %s, it changes once the link sent there is followed.\n\n"+
+		"If you did not ask for this change, change your password and log out of all sessions.",
+		user.Name, user.PendingEmail)
+	if err := s.mailer.Send(user.Email, "Your email is being changed", notice); err != nil {
+		log.Println("[Service:sendEmailChangeEmail] ", err)
+	}
+	return nil
+}
+
+// issueUserToken creates a token for the user valid for ttl, replacing their
+// previous tokens of the same purpose, and returns it to be sent to them.
+func issueUserToken(userRepo repository.UserRepository, userID uint, purpose string, ttl time.Duration) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = userRepo.CreateUserToken(&model.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
 	if err != nil {
 		return "", err
 	}
+	return token, nil
+}
 
-	return newSession.SID, nil
+// formatTTL describes how long an emailed link stays valid, in whole hours or minutes.
+func formatTTL(ttl time.Duration) string {
+	if ttl >= time.Hour && ttl%time.Hour == 0 {
+		return fmt.Sprintf("%d hours", int(ttl.Hours()))
+	}
+	return fmt.Sprintf("%d minutes", int(ttl.Minutes()))
 }
 
-// IsValidSession checks if the session ID (SID) is valid.
-func (s *SessionServiceImpl) IsValidSession(sid string) (uint, bool) {
-	// Delegate the session validation to the UserRepository or your session store
-	// You should implement your session validation logic here
-	return s.userRepo.IsValidSession(sid)
+// ValidateSession checks if the session ID (SID) is valid and returns the userID if valid.
+// It returns myerrors.ErrSessionExpired once the session idled out or reached its lifetime.
+func (s *SessionServiceImpl) ValidateSession(sid string) (uint, error) {
+	session, err := s.sessions.Get(sid)
+	if err != nil {
+		if err == myerrors.ErrRecordNotFound {
+			return 0, myerrors.ErrUnauthorized
+		}
+		return 0, err
+	}
+
+	now := time.Now()
+	if now.After(session.IdleExpiresAt) || now.After(session.ExpiresAt) {
+		return 0, myerrors.ErrSessionExpired
+	}
+
+	return session.UserID, nil
+}
+
+// TouchSession updates the last-seen time of the session.
+func (s *SessionServiceImpl) TouchSession(sid string) error {
+	return s.sessions.Touch(sid)
+}
+
+// GetSessionsOfUser retrieves all active sessions of a user.
+func (s *SessionServiceImpl) GetSessionsOfUser(userID uint) ([]*model.UserSession, error) {
+	return s.sessions.ListByUser(userID)
+}
+
+// RevokeSession ends the session identified by the given SID.
+func (s *SessionServiceImpl) RevokeSession(sid string) error {
+	return s.sessions.Revoke(sid)
+}
+
+// RevokeSessionByID ends the session with the given ID if it belongs to the user.
+func (s *SessionServiceImpl) RevokeSessionByID(userID, sessionID uint) error {
+	sessions, err := s.sessions.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			return s.sessions.Revoke(session.SID)
+		}
+	}
+
+	return myerrors.ErrRecordNotFound
+}
+
+// RevokeAllSessions ends every session of the given user.
+func (s *SessionServiceImpl) RevokeAllSessions(userID uint) error {
+	return s.sessions.RevokeAllOfUser(userID)
+}
+
+// CreateLink creates a public link to a note of the owner and returns it with its token.
+// The token is only known to the caller, the link keeps its hash.
+func (s *publicLinkService) CreateLink(ownerID, noteID uint, expiresAt *time.Time, password string) (*model.PublicLink, string, error) {
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", myerrors.ErrInvalidInput
+	}
+	if _, err := authorizeNote(s.noteRepo, s.shareRepo, ownerID, noteID, model.PermissionOwner); err != nil {
+		return nil, "", err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return nil, "", err
+	}
+	link := &model.PublicLink{NoteID: noteID, TokenHash: hashToken(token), ExpiresAt: expiresAt}
+	if password != "" {
+		if link.PasswordHash, err = generatePasswordHash(password); err != nil {
+			return nil, "", err
+		}
+	}
+
+	link, err = s.linkRepo.CreateLink(link)
+	if err != nil {
+		return nil, "", err
+	}
+	return link, token, nil
+}
+
+// GetLinksOfNote retrieves the public links of a note of the owner.
+func (s *publicLinkService) GetLinksOfNote(ownerID, noteID uint) ([]*model.PublicLink, error) {
+	if _, err := authorizeNote(s.noteRepo, s.shareRepo, ownerID, noteID, model.PermissionOwner); err != nil {
+		return nil, err
+	}
+	return s.linkRepo.GetLinksOfNote(noteID)
+}
+
+// RevokeLink revokes a public link to a note of the owner.
+func (s *publicLinkService) RevokeLink(ownerID, noteID, linkID uint) error {
+	if _, err := authorizeNote(s.noteRepo, s.shareRepo, ownerID, noteID, model.PermissionOwner); err != nil {
+		return err
+	}
+	return s.linkRepo.DeleteLink(noteID, linkID)
+}
+
+// ViewNote retrieves the note of a public link and counts the view. It returns
+// myerrors.ErrExpired for expired links and myerrors.ErrAuthentication if the
+// link has a password that was not given. Clients giving too many wrong passwords
+// are refused with a *myerrors.RetryError.
+func (s *publicLinkService) ViewNote(token, password string, client model.ClientInfo) (*model.Note, error) {
+	link, err := s.linkRepo.GetLinkByTokenHash(hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return nil, myerrors.ErrExpired
+	}
+	if link.PasswordHash != "" {
+		if err := s.checkLinkPassword(link, password, client.IP); err != nil {
+			return nil, err
+		}
+	}
+
+	// Links to notes in the trash stop working until the note is restored
+	note, err := s.noteRepo.FindNoteByID(link.NoteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.linkRepo.RecordView(link.ID); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// checkLinkPassword checks the password of a link given by a client. The check
+// is counted as failed before the password is compared so that concurrent guesses
+// cannot get past the limit, errors of the throttle let the check through.
+func (s *publicLinkService) checkLinkPassword(link *model.PublicLink, password, ip string) error {
+	key := fmt.Sprintf("link:%d:%s", link.ID, ip)
+	if wait, err := s.throttle.BlockedFor(key); err != nil {
+		log.Println("[Service:checkLinkPassword] ", err)
+	} else if wait > 0 {
+		return &myerrors.RetryError{After: wait}
+	}
+
+	failures, err := s.throttle.Fail(key)
+	if err != nil {
+		log.Println("[Service:checkLinkPassword] ", err)
+	}
+	if failures > s.maxFailures {
+		if err := s.throttle.Block(key, s.lockout); err != nil {
+			log.Println("[Service:checkLinkPassword] ", err)
+		}
+		return &myerrors.RetryError{After: s.lockout}
+	}
+
+	if !checkPasswordHash(password, link.PasswordHash) {
+		if failures == s.maxFailures {
+			if err := s.throttle.Block(key, s.lockout); err != nil {
+				log.Println("[Service:checkLinkPassword] ", err)
+			}
+		}
+		return myerrors.ErrAuthentication
+	}
+
+	if failures > 0 {
+		if err := s.throttle.Reset(key); err != nil {
+			log.Println("[Service:checkLinkPassword] ", err)
+		}
+	}
+	return nil
+}
+
+// permissionLevels orders the permissions on a note, higher levels include the lower ones.
+var permissionLevels = map[string]int{
+	model.PermissionRead:  1,
+	model.PermissionEdit:  2,
+	model.PermissionOwner: 3,
+}
+
+// authorizeNote retrieves a note if the user has the given permission on it, either
+// as its owner or through a share. Notes the user cannot see at all are reported as
+// not found when reading and as myerrors.ErrUnauthorized when writing, as are notes
+// the user can see but lacks the permission for.
+func authorizeNote(notes repository.NoteRepository, shares repository.ShareRepository, userID, noteID uint, permission string) (*model.Note, error) {
+	note, err := notes.FindNoteByID(noteID)
+	if err != nil {
+		return nil, err
+	}
+
+	granted, err := notePermission(shares, userID, note)
+	if err != nil {
+		return nil, err
+	}
+
+	if permissionLevels[granted] >= permissionLevels[permission] {
+		return note, nil
+	}
+	if permission == model.PermissionRead {
+		return nil, myerrors.ErrRecordNotFound
+	}
+	return nil, myerrors.ErrUnauthorized
+}
+
+// notePermission returns the permission of the user on a note, empty if they have no access.
+func notePermission(shares repository.ShareRepository, userID uint, note *model.Note) (string, error) {
+	if note.UserID == userID {
+		return model.PermissionOwner, nil
+	}
+
+	share, err := shares.GetShare(note.ID, userID)
+	switch err {
+	case nil:
+		return share.Permission, nil
+	case myerrors.ErrRecordNotFound:
+		return "", nil
+	default:
+		return "", err
+	}
+}
+
+// publishNoteEvent tells the owner of a note and the users it is shared with that it
+// changed. The change is already saved, so failures are only logged.
+func publishNoteEvent(shares repository.ShareRepository, events repository.EventBus, eventType string, note *model.Note) {
+	userIDs := []uint{note.UserID}
+	noteShares, err := shares.GetSharesOfNote(note.ID)
+	if err != nil {
+		log.Println("[Service:publishNoteEvent] ", err)
+	}
+	for _, share := range noteShares {
+		userIDs = append(userIDs, share.UserID)
+	}
+
+	event := &model.NoteEvent{Type: eventType, NoteID: note.ID, Version: note.Version, CreatedAt: time.Now()}
+	if eventType != model.EventNoteDeleted {
+		event.Note = note
+	}
+	if err := events.Publish(event, userIDs); err != nil {
+		log.Println("[Service:publishNoteEvent] ", err)
+	}
+}
+
+// normalizeTagNames trims, lowercases and deduplicates tag names, dropping empty ones.
+func normalizeTagNames(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if len(name) > maxTagNameLength {
+			return nil, myerrors.ErrInvalidInput
+		}
+		seen[name] = true
+		normalized = append(normalized, name)
+	}
+	return normalized, nil
+}
+
+// revisionText returns the text compared by revision diffs, the title followed by the content.
+func revisionText(rev *model.NoteRevision) string {
+	return "# " + rev.Title + "\n" + rev.Content
+}
+
+// generateToken returns a random URL-safe token of 256 bits.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hash under which a token is stored. Tokens are random
+// enough for a fast hash, unlike passwords.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 // Password hash checking function
@@ -146,8 +1310,30 @@ func checkPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
+// passwordHashCost is the bcrypt cost of the password hashes.
+const passwordHashCost = 14
+
 // generatePasswordHash generates a password hash for the given password.
 func generatePasswordHash(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
 	return string(bytes), err
 }
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash returns a password hash of the same cost as those of the
+// users, generated once, to compare passwords against when there is no user.
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		hash, err := generatePasswordHash("dummy password")
+		if err != nil {
+			log.Println("[Service:dummyPasswordHash] ", err)
+			return
+		}
+		dummyHash = hash
+	})
+	return dummyHash
+}
@@ -0,0 +1,285 @@
+package service
+
+import (
+	"accuknox/model"
+	"accuknox/myerrors"
+	"accuknox/repository"
+	"accuknox/secrets"
+	"accuknox/totp"
+	"crypto/rand"
+	"encoding/base32"
+	"log"
+	"strings"
+	"time"
+)
+
+// maxChallengeAttempts is the number of wrong codes after which a login challenge is dropped.
+const maxChallengeAttempts = 5
+
+// totpSkew is the number of time steps the codes are accepted before and after
+// the current one, for authenticators whose clock drifted.
+const totpSkew = 1
+
+// recoveryCodeCount is the number of recovery codes given when two-factor authentication is enabled.
+const recoveryCodeCount = 10
+
+// TwoFactorService provides methods for two-factor authentication with TOTP codes.
+type TwoFactorService interface {
+	Setup(userID uint) (*model.TwoFactorSetup, error)
+	Confirm(userID uint, code string) ([]string, error)
+	Disable(userID uint, password, code string, client model.ClientInfo) error
+	CreateChallenge(userID uint) (string, error)
+	CompleteLogin(challenge, code string, client model.ClientInfo) (*model.LoginResult, error)
+	Verify(user *model.User, code string) error
+}
+
+type twoFactorService struct {
+	userRepo     repository.UserRepository
+	guard        *LoginGuard
+	box          *secrets.Box
+	issuer       string
+	challengeTTL time.Duration
+}
+
+// NewTwoFactorService creates a new TwoFactorService encrypting the secrets with
+// the Box and naming the service issuer in authenticator apps. Logins wait
+// challengeTTL for their code, wrong codes count as failed logins of the LoginGuard.
+// Without a Box two-factor authentication cannot be set up.
+func NewTwoFactorService(userRepo repository.UserRepository, guard *LoginGuard, box *secrets.Box, issuer string, challengeTTL time.Duration) TwoFactorService {
+	return &twoFactorService{userRepo, guard, box, issuer, challengeTTL}
+}
+
+// Setup generates the secret of the authenticator of the user. It only takes
+// effect once confirmed with a code, setting up again replaces the secret.
+func (s *twoFactorService) Setup(userID uint) (*model.TwoFactorSetup, error) {
+	if s.box == nil {
+		return nil, myerrors.ErrNotConfigured
+	}
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, myerrors.ErrConflict
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Println("[Service:Setup] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+	sealed, err := s.box.Seal(secret)
+	if err != nil {
+		log.Println("[Service:Setup] ", err)
+		return nil, myerrors.ErrInternalServer
+	}
+	if err := s.userRepo.SetTOTPSecret(userID, sealed); err != nil {
+		return nil, err
+	}
+
+	return &model.TwoFactorSetup{Secret: secret, URI: totp.URI(s.issuer, user.Email, secret)}, nil
+}
+
+// Confirm enables two-factor authentication with a code of the authenticator
+// that was set up, returning the recovery codes of the user. They are only shown once.
+func (s *twoFactorService) Confirm(userID uint, code string) ([]string, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, myerrors.ErrConflict
+	}
+	if user.TOTPSecret == "" {
+		return nil, myerrors.ErrRecordNotFound
+	}
+
+	step, err := s.checkTOTP(user, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			log.Println("[Service:Confirm] ", err)
+			return nil, myerrors.ErrInternalServer
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	if err := s.userRepo.EnableTwoFactor(userID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable disables two-factor authentication, which takes the password and a
+// code of the user. Wrong ones count as failed logins of the user from the client.
+// It returns myerrors.ErrConflict if it is not enabled.
+func (s *twoFactorService) Disable(userID uint, password, code string, client model.ClientInfo) error {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TwoFactorEnabled {
+		return myerrors.ErrConflict
+	}
+	err = s.guard.Check(user.Email, client.IP, func() error {
+		if !checkPasswordHash(password, user.PasswordHash) {
+			return myerrors.ErrAuthentication
+		}
+		return s.verifyCode(user, code)
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.userRepo.DisableTwoFactor(userID)
+}
+
+// CreateChallenge starts the login of a user whose password was checked,
+// returning the challenge to complete with a code.
+func (s *twoFactorService) CreateChallenge(userID uint) (string, error) {
+	return issueUserToken(s.userRepo, userID, model.TokenLoginChallenge, s.challengeTTL)
+}
+
+// CompleteLogin creates a session for the user of a login challenge given a code
+// of their authenticator or one of their recovery codes. The challenge is dropped
+// after maxChallengeAttempts wrong codes, and wrong codes count as failed logins:
+// locked logins are refused with myerrors.ErrTooManyAttempts and a result telling
+// how long to wait.
+func (s *twoFactorService) CompleteLogin(challenge, code string, client model.ClientInfo) (*model.LoginResult, error) {
+	tokenHash := hashToken(challenge)
+	token, err := s.userRepo.GetUserToken(model.TokenLoginChallenge, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, myerrors.ErrExpired
+	}
+
+	user, err := s.userRepo.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	attempt, wait := s.guard.Attempt(user.Email, client.IP)
+	if wait > 0 {
+		return &model.LoginResult{RetryAfter: wait}, myerrors.ErrTooManyAttempts
+	}
+	if err := s.verifyCode(user, code); err != nil {
+		if err != myerrors.ErrAuthentication {
+			attempt.Release()
+			return nil, err
+		}
+		attempt.Fail()
+		if err := s.userRepo.RecordTokenAttempt(token.ID, maxChallengeAttempts); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+
+	// Use the challenge up, a concurrent login with the same challenge fails here
+	if _, err := s.userRepo.UseUserToken(model.TokenLoginChallenge, tokenHash); err != nil {
+		attempt.Release()
+		return nil, err
+	}
+
+	session, err := s.userRepo.CreateSession(&model.UserSession{UserID: user.ID, IP: client.IP, UserAgent: client.UserAgent})
+	if err != nil {
+		attempt.Release()
+		return nil, err
+	}
+	attempt.Succeed()
+	return &model.LoginResult{SID: session.SID}, nil
+}
+
+// Verify checks a code of a user who enabled two-factor authentication before a
+// sensitive change of their account. It returns myerrors.ErrAuthentication if the code is wrong.
+func (s *twoFactorService) Verify(user *model.User, code string) error {
+	if code == "" {
+		return myerrors.ErrAuthentication
+	}
+	return s.verifyCode(user, code)
+}
+
+// verifyCode checks a code of the authenticator of the user or one of their
+// recovery codes, using it up. It returns myerrors.ErrAuthentication if the code is wrong.
+func (s *twoFactorService) verifyCode(user *model.User, code string) error {
+	code = strings.TrimSpace(code)
+	if !isTOTPCode(code) {
+		err := s.userRepo.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(code)))
+		if err == myerrors.ErrRecordNotFound {
+			return myerrors.ErrAuthentication
+		}
+		return err
+	}
+
+	step, err := s.checkTOTP(user, code)
+	if err != nil {
+		return err
+	}
+	// A code is only accepted once, whoever saw it cannot reuse it
+	if err := s.userRepo.UseTOTPStep(user.ID, step); err != nil {
+		if err == myerrors.ErrConflict {
+			return myerrors.ErrAuthentication
+		}
+		return err
+	}
+	return nil
+}
+
+// checkTOTP checks a code against the secret of the user, returning its time step.
+func (s *twoFactorService) checkTOTP(user *model.User, code string) (int64, error) {
+	if s.box == nil {
+		return 0, myerrors.ErrNotConfigured
+	}
+	secret, err := s.box.Open(user.TOTPSecret)
+	if err != nil {
+		log.Println("[Service:checkTOTP] ", err)
+		return 0, myerrors.ErrInternalServer
+	}
+
+	step, ok, err := totp.Validate(secret, strings.TrimSpace(code), time.Now(), totpSkew)
+	if err != nil {
+		log.Println("[Service:checkTOTP] ", err)
+		return 0, myerrors.ErrInternalServer
+	}
+	if !ok {
+		return 0, myerrors.ErrAuthentication
+	}
+	return step, nil
+}
+
+// isTOTPCode tells whether a code looks like a code of an authenticator rather
+// than a recovery code.
+func isTOTPCode(code string) bool {
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// generateRecoveryCode returns a random recovery code of 10 characters, shown as xxxxx-xxxxx.
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode drops the separators and the case users may type recovery codes with.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
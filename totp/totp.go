@@ -0,0 +1,102 @@
+// Package totp implements the time-based one-time passwords of RFC 6238 used
+// by authenticator apps, with the defaults they all support: HMAC-SHA1, 6 digits
+// and a new code every 30 seconds.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Period is how long a code is valid, in seconds.
+const Period = 30
+
+// Digits is the number of digits of a code.
+const Digits = 6
+
+// secretSize is the size of generated secrets in bytes, the size of a SHA-1 digest.
+const secretSize = 20
+
+// encoding is the base32 form of secrets shown to users, without padding.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret in base32.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// Step returns the time step of t, the number of periods since the Unix epoch.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code of the base32 secret at the given time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000), nil
+}
+
+// Validate checks a code against the base32 secret at time t, accepting the codes
+// of up to skew steps before and after to allow for clock drift. It returns the
+// time step the code belongs to, for callers refusing to accept a code twice.
+func Validate(secret, code string, t time.Time, skew int) (int64, bool, error) {
+	if len(code) != Digits {
+		return 0, false, nil
+	}
+
+	now := Step(t)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		expected, err := Code(secret, now+i)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return now + i, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// URI returns the otpauth URI authenticator apps read from QR codes.
+func URI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(Period))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// decodeSecret decodes a base32 secret, ignoring case and spaces.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
@@ -0,0 +1,58 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the test vectors of RFC 6238, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The RFC lists 8 digit codes, 6 digit codes are their last digits
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		got, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Code at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	step, ok, err := Validate(rfcSecret, "081804", now, 1)
+	if err != nil || !ok || step != Step(now) {
+		t.Errorf("Validate = %d, %v, %v, want step %d", step, ok, err, Step(now))
+	}
+
+	// A code of the previous period is accepted within the skew only
+	if _, ok, _ := Validate(rfcSecret, "081804", now.Add(Period*time.Second), 1); !ok {
+		t.Error("code of the previous period refused")
+	}
+	if _, ok, _ := Validate(rfcSecret, "081804", now.Add(2*Period*time.Second), 1); ok {
+		t.Error("code of two periods ago accepted")
+	}
+	if _, ok, _ := Validate(rfcSecret, "81804", now, 1); ok {
+		t.Error("short code accepted")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Notes", "ana@example.com", rfcSecret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Notes:ana@example.com?") {
+		t.Errorf("URI = %s", uri)
+	}
+	if !strings.Contains(uri, "secret="+rfcSecret) || !strings.Contains(uri, "issuer=Notes") {
+		t.Errorf("URI = %s misses the secret or the issuer", uri)
+	}
+}